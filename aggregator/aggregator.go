@@ -0,0 +1,321 @@
+// Package aggregator batches DogStatsD lines and spans before they reach a
+// transport.MetricsSink/TraceExporter, so a busy Traefik instance sends a
+// handful of large datagrams and one OTLP request per flush interval
+// instead of one syscall/HTTP request per access log line. It also folds
+// per-request duration observations into a DDSketch per tagset rather than
+// shipping a histogram sample for every request.
+package aggregator
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/vhicoputra/traefik-datadog-plugin/transport"
+)
+
+const (
+	// DefaultMaxPayloadBytesUDP is the safe DogStatsD datagram size over UDP
+	// (below the common 1500-byte Ethernet MTU, leaving room for IP/UDP
+	// headers).
+	DefaultMaxPayloadBytesUDP = 1432
+	// DefaultMaxPayloadBytesUDS is the datagram size used when shipping over
+	// a Unix domain socket, which isn't bound by a link MTU.
+	DefaultMaxPayloadBytesUDS = 8192
+	// DefaultBufferFlushInterval is how often queued lines and spans are
+	// flushed even if the payload buffer never fills up.
+	DefaultBufferFlushInterval = 100 * time.Millisecond
+	// DefaultMaxTagsets caps the number of distinct duration sketches kept
+	// at once, so a runaway high-cardinality tag can't OOM the process.
+	DefaultMaxTagsets = 5000
+	// DefaultQueueCapacity bounds the ring buffer of metric lines awaiting
+	// the next flush.
+	DefaultQueueCapacity = 8192
+	// DefaultSketchAlpha is the relative accuracy used for duration
+	// sketches (see DDSketch).
+	DefaultSketchAlpha = DefaultAlpha
+
+	// durationMetric is the metric name whose observations are routed
+	// through per-tagset sketches instead of being written as individual
+	// "|h" histogram samples.
+	durationMetric = "trace.traefik.request.duration"
+	// droppedMetric self-reports lines evicted by the ring buffer.
+	droppedMetric = "traefik_datadog.dropped"
+)
+
+// Config controls batching and sketch behavior. Zero values fall back to
+// the Default* constants via New.
+type Config struct {
+	MaxPayloadBytes     int
+	BufferFlushInterval time.Duration
+	MaxTagsets          int
+	QueueCapacity       int
+	SketchAlpha         float64
+}
+
+// Aggregator batches DogStatsD lines and spans produced by ServeHTTP/
+// processLogLine and flushes them to the wrapped MetricsSink/TraceExporter
+// on a timer, coalescing many small writes into a few large ones.
+type Aggregator struct {
+	sink     transport.MetricsSink
+	exporter transport.TraceExporter
+	cfg      Config
+	logf     func(format string, args ...interface{})
+
+	mu       sync.Mutex
+	queue    []string
+	dropped  uint64
+	sketches *lruSketches
+	spans    []transport.Span
+
+	ticker *time.Ticker
+	done   chan struct{}
+	closed bool
+}
+
+// New builds an Aggregator wrapping sink and exporter. Flush failures are
+// reported through logf (log.Printf is used if logf is nil, matching the
+// plugin/sidecar's own fallback). It does not start flushing until Start
+// is called.
+func New(sink transport.MetricsSink, exporter transport.TraceExporter, cfg Config, logf func(format string, args ...interface{})) *Aggregator {
+	if logf == nil {
+		logf = log.Printf
+	}
+	if cfg.MaxPayloadBytes <= 0 {
+		cfg.MaxPayloadBytes = DefaultMaxPayloadBytesUDP
+	}
+	if cfg.BufferFlushInterval <= 0 {
+		cfg.BufferFlushInterval = DefaultBufferFlushInterval
+	}
+	if cfg.MaxTagsets <= 0 {
+		cfg.MaxTagsets = DefaultMaxTagsets
+	}
+	if cfg.QueueCapacity <= 0 {
+		cfg.QueueCapacity = DefaultQueueCapacity
+	}
+	if cfg.SketchAlpha <= 0 {
+		cfg.SketchAlpha = DefaultSketchAlpha
+	}
+
+	return &Aggregator{
+		sink:     sink,
+		exporter: exporter,
+		cfg:      cfg,
+		logf:     logf,
+		sketches: newLRUSketches(cfg.MaxTagsets),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start launches the background flush goroutine. Safe to call once per
+// Aggregator.
+func (a *Aggregator) Start() {
+	a.ticker = time.NewTicker(a.cfg.BufferFlushInterval)
+	go func() {
+		for {
+			select {
+			case <-a.ticker.C:
+				a.Flush()
+			case <-a.done:
+				return
+			}
+		}
+	}()
+}
+
+// WriteMetric enqueues a pre-rendered DogStatsD line for the next flush,
+// dropping the oldest queued line (and counting it in the self-reported
+// dropped metric) if the ring buffer is full.
+func (a *Aggregator) WriteMetric(line string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.queue) >= a.cfg.QueueCapacity {
+		a.queue = a.queue[1:]
+		a.dropped++
+	}
+	a.queue = append(a.queue, line)
+}
+
+// ObserveDuration folds a request.duration observation (in milliseconds)
+// into the DDSketch for tagString, creating one if this is the first
+// observation for that tagset.
+func (a *Aggregator) ObserveDuration(tagString string, durationMs float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sketches.get(tagString, a.cfg.SketchAlpha).Add(durationMs)
+}
+
+// QueueSpan enqueues a span for the next flush, which ships every queued
+// span in a single transport.TraceExporter.ExportBatch call.
+func (a *Aggregator) QueueSpan(span transport.Span) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.spans = append(a.spans, span)
+}
+
+// Flush packs queued metric lines into datagrams bounded by
+// cfg.MaxPayloadBytes, renders each tagset's duration sketch into
+// distribution and gauge lines, and ships every queued span in one
+// ExportBatch call. It is safe to call concurrently with itself (the
+// flush ticker) and with WriteMetric/ObserveDuration/QueueSpan.
+func (a *Aggregator) Flush() {
+	a.mu.Lock()
+	lines := a.queue
+	a.queue = nil
+	dropped := a.dropped
+	a.dropped = 0
+	sketchLines := a.sketches.render()
+	spans := a.spans
+	a.spans = nil
+	a.mu.Unlock()
+
+	if dropped > 0 {
+		lines = append(lines, fmt.Sprintf("%s:%d|c", droppedMetric, dropped))
+	}
+	lines = append(lines, sketchLines...)
+
+	a.flushLines(lines)
+
+	if len(spans) > 0 {
+		if err := a.exporter.ExportBatch(spans); err != nil {
+			a.logf("Failed to export trace batch: %v", err)
+		}
+	}
+}
+
+// flushLines packs lines into datagrams no larger than cfg.MaxPayloadBytes
+// (DogStatsD datagrams hold multiple "\n"-separated metric lines) and
+// writes each datagram through the sink. A single line longer than the
+// limit is still written, on its own.
+func (a *Aggregator) flushLines(lines []string) {
+	var batch string
+	for _, line := range lines {
+		if batch == "" {
+			batch = line
+			continue
+		}
+		// The trailing +1 is the newline the sink appends to each datagram.
+		if len(batch)+1+len(line)+1 > a.cfg.MaxPayloadBytes {
+			a.writeBatch(batch)
+			batch = line
+			continue
+		}
+		batch = batch + "\n" + line
+	}
+	if batch != "" {
+		a.writeBatch(batch)
+	}
+}
+
+func (a *Aggregator) writeBatch(batch string) {
+	if err := a.sink.Write(batch); err != nil {
+		a.logf("Failed to write metric batch: %v", err)
+	}
+}
+
+// Close stops the flush goroutine, flushes anything still queued, and
+// closes the wrapped sink and exporter. Both are closed even if the sink
+// fails, so a gRPC trace exporter's connection is never leaked just because
+// the metrics sink errored first.
+func (a *Aggregator) Close() error {
+	if a.ticker != nil {
+		a.ticker.Stop()
+	}
+	if !a.closed {
+		a.closed = true
+		close(a.done)
+	}
+	a.Flush()
+	sinkErr := a.sink.Close()
+	exporterErr := a.exporter.Close()
+	if sinkErr != nil {
+		return sinkErr
+	}
+	return exporterErr
+}
+
+// lruSketches is an LRU-capped map of tagset -> DDSketch, so a runaway
+// high-cardinality tag can't grow duration sketches without bound.
+type lruSketches struct {
+	cap     int
+	alpha   float64
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type sketchEntry struct {
+	tagString string
+	sketch    *DDSketch
+}
+
+func newLRUSketches(capacity int) *lruSketches {
+	return &lruSketches{
+		cap:     capacity,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the sketch for tagString, creating it (and evicting the
+// least-recently-used tagset if at capacity) if this is the first
+// observation.
+func (l *lruSketches) get(tagString string, alpha float64) *DDSketch {
+	if elem, ok := l.entries[tagString]; ok {
+		l.order.MoveToFront(elem)
+		return elem.Value.(*sketchEntry).sketch
+	}
+
+	if l.cap > 0 && len(l.entries) >= l.cap {
+		oldest := l.order.Back()
+		if oldest != nil {
+			l.order.Remove(oldest)
+			delete(l.entries, oldest.Value.(*sketchEntry).tagString)
+		}
+	}
+
+	entry := &sketchEntry{tagString: tagString, sketch: NewDDSketch(alpha)}
+	elem := l.order.PushFront(entry)
+	l.entries[tagString] = elem
+	return entry.sketch
+}
+
+// render emits distribution and derived gauge lines for every tracked
+// sketch, then clears them so the next flush interval starts fresh.
+func (l *lruSketches) render() []string {
+	var lines []string
+	for elem := l.order.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*sketchEntry)
+		sketch := entry.sketch
+		if sketch.Count() == 0 {
+			continue
+		}
+
+		count := sketch.Count()
+		sketch.Buckets(func(value float64, n uint64) {
+			rate := 1.0 / float64(n)
+			lines = append(lines, fmt.Sprintf("%s:%.2f|d|@%g|#%s", durationMetric, value, rate, entry.tagString))
+		})
+
+		lines = append(lines,
+			fmt.Sprintf("%s.count:%d|g|#%s", durationMetric, count, entry.tagString),
+			fmt.Sprintf("%s.min:%.2f|g|#%s", durationMetric, sketch.Min(), entry.tagString),
+			fmt.Sprintf("%s.max:%.2f|g|#%s", durationMetric, sketch.Max(), entry.tagString),
+			fmt.Sprintf("%s.avg:%.2f|g|#%s", durationMetric, sketch.Avg(), entry.tagString),
+			fmt.Sprintf("%s.p50:%.2f|g|#%s", durationMetric, sketch.Quantile(0.50), entry.tagString),
+			fmt.Sprintf("%s.p95:%.2f|g|#%s", durationMetric, sketch.Quantile(0.95), entry.tagString),
+			fmt.Sprintf("%s.p99:%.2f|g|#%s", durationMetric, sketch.Quantile(0.99), entry.tagString),
+		)
+	}
+
+	for elem := l.order.Front(); elem != nil; {
+		next := elem.Next()
+		entry := elem.Value.(*sketchEntry)
+		delete(l.entries, entry.tagString)
+		l.order.Remove(elem)
+		elem = next
+	}
+
+	return lines
+}
@@ -0,0 +1,157 @@
+package aggregator
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultAlpha is the relative-accuracy target used for duration sketches:
+// any reconstructed quantile is within 1% of the true value.
+const DefaultAlpha = 0.01
+
+// DDSketch is a mergeable, logarithmic-bucket quantile sketch in the style
+// of Datadog's DDSketch: every bucket covers a value range whose relative
+// width is bounded by alpha, so memory stays sub-linear in the number of
+// observations regardless of the value distribution. Buckets are keyed by
+// index rather than value, which makes Merge a plain map union.
+type DDSketch struct {
+	alpha    float64
+	gamma    float64
+	logGamma float64
+	buckets  map[int]uint64
+	count    uint64
+	sum      float64
+	min      float64
+	max      float64
+}
+
+// NewDDSketch builds a DDSketch with the given relative accuracy (e.g. 0.01
+// for 1%). Smaller alpha means finer buckets and more memory per tagset.
+func NewDDSketch(alpha float64) *DDSketch {
+	gamma := (1 + alpha) / (1 - alpha)
+	return &DDSketch{
+		alpha:    alpha,
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		buckets:  make(map[int]uint64),
+		min:      math.Inf(1),
+		max:      math.Inf(-1),
+	}
+}
+
+// Add records one observation of value (must be > 0; non-positive values
+// are ignored since the log-bucket scheme is undefined for them).
+func (s *DDSketch) Add(value float64) {
+	if value <= 0 {
+		return
+	}
+	idx := s.index(value)
+	s.buckets[idx]++
+	s.count++
+	s.sum += value
+	if value < s.min {
+		s.min = value
+	}
+	if value > s.max {
+		s.max = value
+	}
+}
+
+func (s *DDSketch) index(value float64) int {
+	return int(math.Ceil(math.Log(value) / s.logGamma))
+}
+
+// bucketValue reconstructs the representative value for a bucket index,
+// 2*gamma^idx/(gamma+1), whose relative distance to either end of the
+// bucket's range (gamma^(idx-1), gamma^idx] is exactly alpha.
+func (s *DDSketch) bucketValue(idx int) float64 {
+	return 2 * math.Pow(s.gamma, float64(idx)) / (s.gamma + 1)
+}
+
+// Merge folds other's buckets into s. Used to combine sketches observed
+// concurrently for the same tagset before a flush.
+func (s *DDSketch) Merge(other *DDSketch) {
+	if other == nil {
+		return
+	}
+	for idx, n := range other.buckets {
+		s.buckets[idx] += n
+	}
+	s.count += other.count
+	s.sum += other.sum
+	if other.min < s.min {
+		s.min = other.min
+	}
+	if other.max > s.max {
+		s.max = other.max
+	}
+}
+
+// Count returns the number of observations recorded.
+func (s *DDSketch) Count() uint64 {
+	return s.count
+}
+
+// Min returns the smallest observed value, or 0 if empty.
+func (s *DDSketch) Min() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.min
+}
+
+// Max returns the largest observed value, or 0 if empty.
+func (s *DDSketch) Max() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.max
+}
+
+// Avg returns the mean of all observed values, or 0 if empty.
+func (s *DDSketch) Avg() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.sum / float64(s.count)
+}
+
+// Quantile reconstructs the value at quantile q (0..1) from the bucket
+// counts, accurate to within the sketch's configured relative accuracy.
+func (s *DDSketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	var rank uint64
+	if r := math.Ceil(q * float64(s.count)); r > 1 {
+		rank = uint64(r) - 1
+	}
+	indexes := make([]int, 0, len(s.buckets))
+	for idx := range s.buckets {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+
+	var cumulative uint64
+	for _, idx := range indexes {
+		cumulative += s.buckets[idx]
+		if cumulative > rank {
+			return s.bucketValue(idx)
+		}
+	}
+	return s.Max()
+}
+
+// Buckets calls fn once per non-empty bucket with the bucket's
+// representative value and observation count, in ascending value order.
+// Used to render the DogStatsD "|d" distribution payload at flush time.
+func (s *DDSketch) Buckets(fn func(value float64, count uint64)) {
+	indexes := make([]int, 0, len(s.buckets))
+	for idx := range s.buckets {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+	for _, idx := range indexes {
+		fn(s.bucketValue(idx), s.buckets[idx])
+	}
+}
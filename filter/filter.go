@@ -0,0 +1,143 @@
+// Package filter provides telegraf-style namepass/namedrop/tagpass/tagdrop
+// matching so operators can route or drop metrics and traces without
+// recompiling the plugin. Matchers are compiled once up front so evaluating
+// a rule set on the request hot path never allocates.
+package filter
+
+import "strings"
+
+// matchKind identifies which shape of glob a Matcher was compiled for.
+type matchKind int
+
+const (
+	kindAny matchKind = iota
+	kindExact
+	kindPrefix
+	kindSuffix
+	kindContains
+)
+
+// Matcher matches a single glob pattern against a string. Only a single
+// leading and/or trailing "*" is supported (e.g. "api@*", "*.docker",
+// "*internal*"), which covers the telegraf-style filters operators
+// actually write and keeps matching branch-free and allocation-free.
+type Matcher struct {
+	kind matchKind
+	part string
+}
+
+// Compile compiles a single glob pattern into a Matcher.
+func Compile(pattern string) *Matcher {
+	switch {
+	case pattern == "" || pattern == "*":
+		return &Matcher{kind: kindAny}
+	case strings.HasPrefix(pattern, "*") && strings.HasSuffix(pattern, "*") && len(pattern) > 1:
+		return &Matcher{kind: kindContains, part: pattern[1 : len(pattern)-1]}
+	case strings.HasSuffix(pattern, "*"):
+		return &Matcher{kind: kindPrefix, part: pattern[:len(pattern)-1]}
+	case strings.HasPrefix(pattern, "*"):
+		return &Matcher{kind: kindSuffix, part: pattern[1:]}
+	default:
+		return &Matcher{kind: kindExact, part: pattern}
+	}
+}
+
+// Match reports whether s satisfies the compiled pattern.
+func (m *Matcher) Match(s string) bool {
+	switch m.kind {
+	case kindAny:
+		return true
+	case kindPrefix:
+		return strings.HasPrefix(s, m.part)
+	case kindSuffix:
+		return strings.HasSuffix(s, m.part)
+	case kindContains:
+		return strings.Contains(s, m.part)
+	default:
+		return s == m.part
+	}
+}
+
+// List is a compiled set of patterns matched with OR semantics.
+type List []*Matcher
+
+// CompileList compiles a slice of glob patterns into a List.
+func CompileList(patterns []string) List {
+	if len(patterns) == 0 {
+		return nil
+	}
+	list := make(List, 0, len(patterns))
+	for _, p := range patterns {
+		list = append(list, Compile(p))
+	}
+	return list
+}
+
+// MatchAny reports whether s satisfies any pattern in the list.
+func (l List) MatchAny(s string) bool {
+	for _, m := range l {
+		if m.Match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules is a compiled telegraf-style filter: namepass/namedrop gate on a
+// single "name" (e.g. a router name or hostname), tagpass/tagdrop gate on
+// a set of tag values. An empty Rules keeps everything.
+type Rules struct {
+	namePass List
+	nameDrop List
+	tagPass  map[string]List
+	tagDrop  map[string]List
+}
+
+// NewRules compiles namepass/namedrop patterns and tagpass/tagdrop maps
+// (tag name -> allowed/blocked value patterns) into a Rules.
+func NewRules(namepass, namedrop []string, tagpass, tagdrop map[string][]string) *Rules {
+	r := &Rules{
+		namePass: CompileList(namepass),
+		nameDrop: CompileList(namedrop),
+	}
+	if len(tagpass) > 0 {
+		r.tagPass = make(map[string]List, len(tagpass))
+		for tag, patterns := range tagpass {
+			r.tagPass[tag] = CompileList(patterns)
+		}
+	}
+	if len(tagdrop) > 0 {
+		r.tagDrop = make(map[string]List, len(tagdrop))
+		for tag, patterns := range tagdrop {
+			r.tagDrop[tag] = CompileList(patterns)
+		}
+	}
+	return r
+}
+
+// Keep reports whether a line identified by name (e.g. RouterName or
+// hostname) and its tag values should pass (true) or be dropped (false).
+// A nil Rules keeps everything, matching the "no filters configured" default.
+func (r *Rules) Keep(name string, tags map[string]string) bool {
+	if r == nil {
+		return true
+	}
+	if len(r.nameDrop) > 0 && r.nameDrop.MatchAny(name) {
+		return false
+	}
+	if len(r.namePass) > 0 && !r.namePass.MatchAny(name) {
+		return false
+	}
+	for tag, list := range r.tagDrop {
+		if val, ok := tags[tag]; ok && list.MatchAny(val) {
+			return false
+		}
+	}
+	for tag, list := range r.tagPass {
+		val, ok := tags[tag]
+		if !ok || !list.MatchAny(val) {
+			return false
+		}
+	}
+	return true
+}
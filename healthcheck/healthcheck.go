@@ -0,0 +1,293 @@
+// Package healthcheck actively probes the backends behind each known
+// Traefik service, independently of live request traffic — the same idea
+// as a load balancer that checks each real backend on its own cadence
+// rather than inferring health purely from request outcomes. Results are
+// folded into the same aggregator the metrics pipeline uses, so probes
+// are batched and rate-limited exactly like request metrics instead of
+// bypassing the aggregator's flush cadence with their own writes.
+package healthcheck
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vhicoputra/traefik-datadog-plugin/aggregator"
+)
+
+const (
+	// DefaultInterval is how often each target is probed.
+	DefaultInterval = 30 * time.Second
+	// DefaultTimeout bounds how long the Scheduler waits for a single
+	// probe attempt before counting it as down.
+	DefaultTimeout = 5 * time.Second
+	// DefaultRetries is how many additional attempts a failing probe gets
+	// before the Scheduler reports it down, to avoid flapping on a single
+	// dropped packet.
+	DefaultRetries = 2
+	// tickInterval is the Scheduler's internal polling granularity. Actual
+	// per-target cadence is governed by Interval plus jitter, not this
+	// value; it just needs to be fine-grained enough that a target's
+	// jittered due time is never missed by more than a second or so.
+	tickInterval = 1 * time.Second
+
+	upMetric       = "trace.traefik.service.up"
+	durationMetric = "trace.traefik.service.probe.duration"
+)
+
+// ProbeFunc checks whether target is reachable, returning its health, the
+// probe's latency, and a free-form detail string (an error message or
+// response status) used only for logging. DefaultProbe issues an HTTP GET;
+// override it to, say, run the check inside a network namespace against
+// the NAT'd backend address, or issue a gRPC health-check RPC instead.
+type ProbeFunc func(service string, target *url.URL) (up bool, latency time.Duration, detail string)
+
+// DefaultProbe issues an HTTP GET against target, bounded by DefaultTimeout.
+// Any 2xx/3xx response (or one Traefik's backend could plausibly return from
+// a health endpoint) counts as up; a non-2xx/3xx status or a transport error
+// counts as down. New binds a Config's own Timeout instead of calling this
+// directly, so DefaultProbe's fixed timeout only applies when it's used
+// outside a Scheduler.
+func DefaultProbe(service string, target *url.URL) (up bool, latency time.Duration, detail string) {
+	return httpProbe(DefaultTimeout, service, target)
+}
+
+// httpProbe is DefaultProbe's implementation with the client timeout
+// factored out, so New can bind it to the Scheduler's configured Timeout
+// instead of always using DefaultTimeout.
+func httpProbe(timeout time.Duration, service string, target *url.URL) (up bool, latency time.Duration, detail string) {
+	client := &http.Client{Timeout: timeout}
+	start := time.Now()
+	resp, err := client.Get(target.String())
+	latency = time.Since(start)
+	if err != nil {
+		return false, latency, err.Error()
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode < 400, latency, resp.Status
+}
+
+// Target is one service endpoint the Scheduler probes on its own cadence.
+type Target struct {
+	Service string
+	URL     *url.URL
+}
+
+// Config controls probe cadence and the function used to check each
+// target. A zero Interval or Timeout falls back to DefaultInterval or
+// DefaultTimeout via New; a negative Retries falls back to DefaultRetries,
+// while zero Retries means a single attempt per probe.
+type Config struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	Retries  int
+	Probe    ProbeFunc
+
+	// Environment is attached to every probe metric as the "env:" tag,
+	// matching the rest of the metrics pipeline.
+	Environment string
+}
+
+// Scheduler probes every registered Target on a jittered per-target
+// cadence and writes the results through an aggregator.Aggregator, the
+// same one the request-metrics pipeline uses. A single background
+// goroutine drives every target; per-target jitter (rather than one
+// goroutine/ticker per target) keeps probes spread out instead of all
+// firing in the same instant.
+type Scheduler struct {
+	agg  *aggregator.Aggregator
+	cfg  Config
+	logf func(format string, args ...interface{})
+
+	mu      sync.Mutex
+	targets map[string]*scheduledTarget
+
+	ticker *time.Ticker
+	done   chan struct{}
+	closed bool
+}
+
+type scheduledTarget struct {
+	target  Target
+	nextDue time.Time
+}
+
+// New builds a Scheduler that reports through agg. It does not start
+// probing until Start is called.
+func New(agg *aggregator.Aggregator, cfg Config, logf func(format string, args ...interface{})) *Scheduler {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.Retries < 0 {
+		cfg.Retries = DefaultRetries
+	}
+	if cfg.Probe == nil {
+		timeout := cfg.Timeout
+		cfg.Probe = func(service string, target *url.URL) (up bool, latency time.Duration, detail string) {
+			return httpProbe(timeout, service, target)
+		}
+	}
+	return &Scheduler{
+		agg:     agg,
+		cfg:     cfg,
+		logf:    logf,
+		targets: make(map[string]*scheduledTarget),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start launches the background probing goroutine. Safe to call once per
+// Scheduler.
+func (s *Scheduler) Start() {
+	s.ticker = time.NewTicker(tickInterval)
+	go func() {
+		for {
+			select {
+			case <-s.ticker.C:
+				s.probeDue()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+// AddTarget registers a service endpoint to probe, jittering its first
+// probe within one Interval so newly discovered targets (e.g. a service
+// seen for the first time in an access log) don't all line up with
+// targets registered earlier. Re-registering an already-known
+// service+URL pair is a no-op, so callers can call this on every request
+// without resetting its schedule.
+func (s *Scheduler) AddTarget(service string, target *url.URL) {
+	key := targetKey(service, target)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.targets[key]; exists {
+		return
+	}
+	s.targets[key] = &scheduledTarget{
+		target:  Target{Service: service, URL: target},
+		nextDue: time.Now().Add(jitter(s.cfg.Interval)),
+	}
+}
+
+func targetKey(service string, target *url.URL) string {
+	return service + "|" + target.String()
+}
+
+// jitter returns a random duration in [0, interval), so probes registered
+// at the same time don't all fire on the same tick.
+func jitter(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(interval)))
+}
+
+// probeDue finds every target whose nextDue has passed and probes them,
+// one at a time, on the Scheduler's single background goroutine.
+func (s *Scheduler) probeDue() {
+	now := time.Now()
+
+	s.mu.Lock()
+	var due []*scheduledTarget
+	for _, st := range s.targets {
+		if !st.nextDue.After(now) {
+			due = append(due, st)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, st := range due {
+		s.probe(st.target)
+
+		s.mu.Lock()
+		st.nextDue = time.Now().Add(s.cfg.Interval + jitter(s.cfg.Interval/10))
+		s.mu.Unlock()
+	}
+}
+
+// probe runs the configured ProbeFunc against target, retrying on failure
+// up to cfg.Retries times before reporting it down, and writes the
+// resulting up/duration metrics through the aggregator.
+func (s *Scheduler) probe(target Target) {
+	var up bool
+	var latency time.Duration
+	var detail string
+
+	for attempt := 0; attempt <= s.cfg.Retries; attempt++ {
+		up, latency, detail = s.runProbe(target)
+		if up {
+			break
+		}
+	}
+	if !up {
+		s.logf("Healthcheck probe for service %q (%s) failed: %s", target.Service, target.URL, detail)
+	}
+
+	tagString := strings.Join([]string{
+		fmt.Sprintf("service:%s", sanitizeTagValue(target.Service)),
+		fmt.Sprintf("env:%s", sanitizeTagValue(s.cfg.Environment)),
+		fmt.Sprintf("url:%s", sanitizeTagValue(target.URL.String())),
+	}, ",")
+
+	upValue := 0
+	if up {
+		upValue = 1
+	}
+	s.agg.WriteMetric(fmt.Sprintf("%s:%d|g|#%s", upMetric, upValue, tagString))
+	s.agg.WriteMetric(fmt.Sprintf("%s:%.2f|h|#%s", durationMetric, float64(latency.Nanoseconds())/1e6, tagString))
+}
+
+// runProbe bounds a single ProbeFunc call to cfg.Timeout, so a custom
+// Probe that ignores the deadline itself (e.g. a blocking namespace exec)
+// still can't wedge the Scheduler's single background goroutine for longer
+// than cfg.Timeout per attempt; on timeout the probe goroutine is abandoned
+// rather than killed.
+func (s *Scheduler) runProbe(target Target) (up bool, latency time.Duration, detail string) {
+	type result struct {
+		up      bool
+		latency time.Duration
+		detail  string
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		up, latency, detail := s.cfg.Probe(target.Service, target.URL)
+		resultCh <- result{up, latency, detail}
+	}()
+
+	select {
+	case r := <-resultCh:
+		return r.up, r.latency, r.detail
+	case <-time.After(s.cfg.Timeout):
+		return false, s.cfg.Timeout, "probe timed out"
+	}
+}
+
+// Close stops the probing goroutine. Safe to call more than once.
+func (s *Scheduler) Close() {
+	if s.ticker != nil {
+		s.ticker.Stop()
+	}
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
+
+// sanitizeTagValue replaces characters that break DogStatsD tag format
+// (comma, pipe, newline in a tag value), matching the sidecar's own tag
+// sanitization.
+func sanitizeTagValue(s string) string {
+	s = strings.ReplaceAll(s, ",", "_")
+	s = strings.ReplaceAll(s, "|", "_")
+	s = strings.ReplaceAll(s, "\n", "_")
+	return s
+}
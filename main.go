@@ -1,60 +1,105 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/vhicoputra/traefik-datadog-plugin/aggregator"
+	"github.com/vhicoputra/traefik-datadog-plugin/filter"
+	"github.com/vhicoputra/traefik-datadog-plugin/healthcheck"
+	"github.com/vhicoputra/traefik-datadog-plugin/transport"
 )
 
+// InterfacesConfig groups the transports metrics and traces are shipped
+// over, mirroring how each is independently dialed and configured.
+type InterfacesConfig struct {
+	DogStatsD transport.DogStatsDConfig `json:"dogstatsd,omitempty"`
+	OTLP      transport.OTLPConfig      `json:"otlp,omitempty"`
+}
+
+// HealthcheckConfig configures the active backend probe subsystem (see
+// package healthcheck). Targets maps a service name to the URL probed on
+// its behalf; a middleware instance only ever sees the services that pass
+// through it, so unlike the sidecar (which discovers ServiceName from
+// every access log line) this list has to be supplied up front.
+type HealthcheckConfig struct {
+	Enabled    bool              `json:"enabled,omitempty"`
+	IntervalMS int               `json:"intervalMs,omitempty"`
+	TimeoutMS  int               `json:"timeoutMs,omitempty"`
+	Retries    int               `json:"retries,omitempty"`
+	Targets    map[string]string `json:"targets,omitempty"`
+	// Probe overrides the default HTTP GET probe when set. It has no JSON
+	// form (a func value isn't JSON-serializable), and New builds its
+	// Config from the decoded config map without ever setting Probe, so a
+	// plugin created through New always uses the default HTTP GET probe.
+	Probe healthcheck.ProbeFunc `json:"-"`
+}
+
 type Config struct {
-	DogStatsDAddress string `json:"dogstatsdAddress,omitempty"`
-	APMAddress       string `json:"apmAddress,omitempty"`
-	OTLPEndpoint     string `json:"otlpEndpoint,omitempty"`
-	ServiceName      string `json:"serviceName,omitempty"`
-	Environment      string `json:"environment,omitempty"`
-	Version          string `json:"version,omitempty"`
-	SampleRate       float64 `json:"sampleRate,omitempty"`
-	ApdexThreshold   float64 `json:"apdexThreshold,omitempty"`
+	Alias      string           `json:"alias,omitempty"`
+	Interfaces InterfacesConfig `json:"interfaces,omitempty"`
+	// TestSink routes metrics/traces to stdout (via transport.TestMetricsSink
+	// and transport.TestTraceExporter) instead of the configured Interfaces,
+	// so operators can validate tag derivation, Apdex bucketing, and
+	// status-code handling against real traffic before pointing at a live
+	// Agent.
+	TestSink       bool                `json:"testSink,omitempty"`
+	ServiceName    string              `json:"serviceName,omitempty"`
+	Environment    string              `json:"environment,omitempty"`
+	Version        string              `json:"version,omitempty"`
+	SampleRate     float64             `json:"sampleRate,omitempty"`
+	ApdexThreshold float64             `json:"apdexThreshold,omitempty"`
+	Tags           map[string]string   `json:"tags,omitempty"`
+	NamePass       []string            `json:"namepass,omitempty"`
+	NameDrop       []string            `json:"namedrop,omitempty"`
+	TagPass        map[string][]string `json:"tagpass,omitempty"`
+	TagDrop        map[string][]string `json:"tagdrop,omitempty"`
+	Healthcheck    HealthcheckConfig   `json:"healthcheck,omitempty"`
 }
 
 type DatadogPlugin struct {
 	config         *Config
-	statsdConn     *net.UDPConn
-	otlpClient     *http.Client
+	aggregator     *aggregator.Aggregator
+	healthchecker  *healthcheck.Scheduler
 	next           http.Handler
 	name           string
+	alias          string
 	apdexThreshold float64
+	staticTags     []string
+	filter         *filter.Rules
 }
 
 func New(ctx context.Context, next http.Handler, config map[string]interface{}, name string) (http.Handler, error) {
+	ddAgentHost := getEnv("DD_AGENT_HOST", "datadog-apm.datadog.svc")
 	cfg := &Config{
-		DogStatsDAddress: getEnv("DD_AGENT_HOST", "datadog-apm.datadog.svc") + ":8127",
-		APMAddress:       getEnv("DD_AGENT_HOST", "datadog-apm.datadog.svc") + ":8126",
-		OTLPEndpoint:     "http://" + getEnv("DD_AGENT_HOST", "datadog-apm.datadog.svc") + ":4318/v1/traces",
-		ServiceName:      getEnv("DD_SERVICE", "traefik"),
-		Environment:      getEnv("DD_ENV", "staging"),
-		Version:          getEnv("TRAEFIK_VERSION", "3.6.5"),
-		SampleRate:       1.0,
-		ApdexThreshold:   0.5,
+		Interfaces: InterfacesConfig{
+			DogStatsD: transport.DogStatsDConfig{
+				Address: ddAgentHost + ":8127",
+				Network: "udp",
+			},
+			OTLP: transport.OTLPConfig{
+				Protocol: "http/json",
+				Endpoint: "http://" + ddAgentHost + ":4318/v1/traces",
+			},
+		},
+		ServiceName:    getEnv("DD_SERVICE", "traefik"),
+		Environment:    getEnv("DD_ENV", "staging"),
+		Version:        getEnv("TRAEFIK_VERSION", "3.6.5"),
+		SampleRate:     1.0,
+		ApdexThreshold: 0.5,
 	}
 
 	if config != nil {
-		if addr, ok := config["dogstatsdAddress"].(string); ok && addr != "" {
-			cfg.DogStatsDAddress = addr
-		}
-		if addr, ok := config["apmAddress"].(string); ok && addr != "" {
-			cfg.APMAddress = addr
-		}
-		if endpoint, ok := config["otlpEndpoint"].(string); ok && endpoint != "" {
-			cfg.OTLPEndpoint = endpoint
+		if interfaces, ok := config["interfaces"].(map[string]interface{}); ok {
+			applyInterfacesConfig(&cfg.Interfaces, interfaces)
 		}
 		if svc, ok := config["serviceName"].(string); ok && svc != "" {
 			cfg.ServiceName = svc
@@ -71,34 +116,242 @@ func New(ctx context.Context, next http.Handler, config map[string]interface{},
 		if threshold, ok := config["apdexThreshold"].(float64); ok {
 			cfg.ApdexThreshold = threshold
 		}
+		if alias, ok := config["alias"].(string); ok && alias != "" {
+			cfg.Alias = alias
+		}
+		if testSink, ok := config["testSink"].(bool); ok {
+			cfg.TestSink = testSink
+		}
+		if tags, ok := config["tags"]; ok {
+			cfg.Tags = parseStringMap(tags)
+		}
+		if namepass, ok := config["namepass"]; ok {
+			cfg.NamePass = parseStringSlice(namepass)
+		}
+		if namedrop, ok := config["namedrop"]; ok {
+			cfg.NameDrop = parseStringSlice(namedrop)
+		}
+		if tagpass, ok := config["tagpass"]; ok {
+			cfg.TagPass = parseTagFilterMap(tagpass)
+		}
+		if tagdrop, ok := config["tagdrop"]; ok {
+			cfg.TagDrop = parseTagFilterMap(tagdrop)
+		}
+		if hc, ok := config["healthcheck"].(map[string]interface{}); ok {
+			applyHealthcheckConfig(&cfg.Healthcheck, hc)
+		}
 	}
 
-	addr, err := net.ResolveUDPAddr("udp", cfg.DogStatsDAddress)
-	if err != nil {
-		return nil, fmt.Errorf("failed to resolve DogStatsD address: %w", err)
-	}
+	var metricsSink transport.MetricsSink
+	var traceExporter transport.TraceExporter
+	if cfg.TestSink {
+		metricsSink = transport.NewTestMetricsSink(os.Stdout)
+		traceExporter = transport.NewTestTraceExporter(os.Stdout)
+	} else {
+		var err error
+		metricsSink, err = transport.NewMetricsSink(cfg.Interfaces.DogStatsD)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create DogStatsD sink: %w", err)
+		}
 
-	statsdConn, err := net.DialUDP("udp", nil, addr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create DogStatsD connection: %w", err)
+		traceExporter, err = transport.NewTraceExporter(cfg.Interfaces.OTLP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+		}
 	}
 
-	otlpClient := &http.Client{
-		Timeout: 5 * time.Second,
+	maxPayloadBytes := cfg.Interfaces.DogStatsD.MaxPayloadBytes
+	if maxPayloadBytes <= 0 && cfg.Interfaces.DogStatsD.Network == "unixgram" {
+		maxPayloadBytes = aggregator.DefaultMaxPayloadBytesUDS
+	}
+	agg := aggregator.New(metricsSink, traceExporter, aggregator.Config{
+		MaxPayloadBytes:     maxPayloadBytes,
+		BufferFlushInterval: time.Duration(cfg.Interfaces.DogStatsD.BufferFlushIntervalMS) * time.Millisecond,
+	}, aliasLogf(cfg.Alias))
+	agg.Start()
+
+	// The healthcheck scheduler shares agg, so probe results are batched
+	// and rate-limited exactly like request metrics instead of bypassing
+	// the aggregator with their own writes.
+	var healthchecker *healthcheck.Scheduler
+	if cfg.Healthcheck.Enabled {
+		healthchecker = healthcheck.New(agg, healthcheck.Config{
+			Interval:    time.Duration(cfg.Healthcheck.IntervalMS) * time.Millisecond,
+			Timeout:     time.Duration(cfg.Healthcheck.TimeoutMS) * time.Millisecond,
+			Retries:     cfg.Healthcheck.Retries,
+			Probe:       cfg.Healthcheck.Probe,
+			Environment: cfg.Environment,
+		}, aliasLogf(cfg.Alias))
+		for service, target := range cfg.Healthcheck.Targets {
+			u, err := url.Parse(target)
+			if err != nil {
+				return nil, fmt.Errorf("invalid healthcheck target %q for service %q: %w", target, service, err)
+			}
+			healthchecker.AddTarget(service, u)
+		}
+		if !cfg.TestSink {
+			healthchecker.Start()
+		}
 	}
 
 	plugin := &DatadogPlugin{
 		config:         cfg,
-		statsdConn:     statsdConn,
-		otlpClient:     otlpClient,
+		aggregator:     agg,
+		healthchecker:  healthchecker,
 		next:           next,
 		name:           name,
+		alias:          cfg.Alias,
 		apdexThreshold: cfg.ApdexThreshold,
+		staticTags:     staticTagStrings(cfg.Tags),
+		filter:         filter.NewRules(cfg.NamePass, cfg.NameDrop, cfg.TagPass, cfg.TagDrop),
 	}
 
 	return plugin, nil
 }
 
+// aliasLogf builds the log.Printf-compatible closure handed to the
+// aggregator, so batching/flush failures get the same "[alias] " prefix as
+// every other log line this plugin instance emits.
+func aliasLogf(alias string) func(format string, args ...interface{}) {
+	return func(format string, args ...interface{}) {
+		if alias != "" {
+			format = "[" + alias + "] " + format
+		}
+		log.Printf(format, args...)
+	}
+}
+
+// applyInterfacesConfig overlays a yaegi-decoded "interfaces" config block
+// onto the defaults in dst.
+func applyInterfacesConfig(dst *InterfacesConfig, interfaces map[string]interface{}) {
+	if dogstatsd, ok := interfaces["dogstatsd"].(map[string]interface{}); ok {
+		if addr, ok := dogstatsd["address"].(string); ok && addr != "" {
+			dst.DogStatsD.Address = addr
+		}
+		if network, ok := dogstatsd["network"].(string); ok && network != "" {
+			dst.DogStatsD.Network = network
+		}
+		if max, ok := dogstatsd["maxPayloadBytes"].(float64); ok {
+			dst.DogStatsD.MaxPayloadBytes = int(max)
+		}
+		if flush, ok := dogstatsd["bufferFlushIntervalMs"].(float64); ok {
+			dst.DogStatsD.BufferFlushIntervalMS = int(flush)
+		}
+	}
+	if otlp, ok := interfaces["otlp"].(map[string]interface{}); ok {
+		if protocol, ok := otlp["protocol"].(string); ok && protocol != "" {
+			dst.OTLP.Protocol = protocol
+		}
+		if endpoint, ok := otlp["endpoint"].(string); ok && endpoint != "" {
+			dst.OTLP.Endpoint = endpoint
+		}
+		if headers, ok := otlp["headers"]; ok {
+			dst.OTLP.Headers = parseStringMap(headers)
+		}
+		if compression, ok := otlp["compression"].(string); ok && compression != "" {
+			dst.OTLP.Compression = compression
+		}
+		if tlsCfg, ok := otlp["tls"].(map[string]interface{}); ok {
+			if skip, ok := tlsCfg["insecureSkipVerify"].(bool); ok {
+				dst.OTLP.TLS.InsecureSkipVerify = skip
+			}
+			if ca, ok := tlsCfg["caFile"].(string); ok {
+				dst.OTLP.TLS.CAFile = ca
+			}
+			if cert, ok := tlsCfg["certFile"].(string); ok {
+				dst.OTLP.TLS.CertFile = cert
+			}
+			if key, ok := tlsCfg["keyFile"].(string); ok {
+				dst.OTLP.TLS.KeyFile = key
+			}
+		}
+	}
+}
+
+// applyHealthcheckConfig overlays a yaegi-decoded "healthcheck" config
+// block onto the defaults in dst.
+func applyHealthcheckConfig(dst *HealthcheckConfig, hc map[string]interface{}) {
+	if enabled, ok := hc["enabled"].(bool); ok {
+		dst.Enabled = enabled
+	}
+	if interval, ok := hc["intervalMs"].(float64); ok {
+		dst.IntervalMS = int(interval)
+	}
+	if timeout, ok := hc["timeoutMs"].(float64); ok {
+		dst.TimeoutMS = int(timeout)
+	}
+	if retries, ok := hc["retries"].(float64); ok {
+		dst.Retries = int(retries)
+	}
+	if targets, ok := hc["targets"]; ok {
+		dst.Targets = parseStringMap(targets)
+	}
+}
+
+// staticTagStrings renders a static tag map into sorted "key:value" pairs
+// so each alias's extra tags are appended in a stable order.
+func staticTagStrings(tags map[string]string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, fmt.Sprintf("%s:%s", k, tags[k]))
+	}
+	return out
+}
+
+// parseStringMap converts a yaegi-decoded map[string]interface{} (as
+// delivered in the plugin's dynamic config) into a map[string]string.
+func parseStringMap(v interface{}) map[string]string {
+	raw, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	out := make(map[string]string, len(raw))
+	for k, val := range raw {
+		if s, ok := val.(string); ok {
+			out[k] = s
+		}
+	}
+	return out
+}
+
+// parseStringSlice converts a yaegi-decoded []interface{} into a []string.
+func parseStringSlice(v interface{}) []string {
+	raw, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	out := make([]string, 0, len(raw))
+	for _, val := range raw {
+		if s, ok := val.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// parseTagFilterMap converts a yaegi-decoded map[string]interface{} whose
+// values are []interface{} (as tagpass/tagdrop configs arrive) into the
+// map[string][]string shape filter.NewRules expects.
+func parseTagFilterMap(v interface{}) map[string][]string {
+	raw, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	out := make(map[string][]string, len(raw))
+	for tag, patterns := range raw {
+		out[tag] = parseStringSlice(patterns)
+	}
+	return out
+}
+
 func (p *DatadogPlugin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	startTime := time.Now()
 
@@ -134,6 +387,22 @@ func (p *DatadogPlugin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		apdex = 0.5
 	}
 
+	// Router name isn't available to an HTTP middleware at request time
+	// (unlike the sidecar, which reads RouterName straight off the access
+	// log), so namepass/namedrop and tagpass/tagdrop match on hostname and
+	// the tags already derived below.
+	filterTags := map[string]string{
+		"peer.hostname":    hostname,
+		"http.status_code": statusCodeStr,
+		"http.method":      method,
+		"service":          p.config.ServiceName,
+		"env":              p.config.Environment,
+		"version":          p.config.Version,
+	}
+	if !p.filter.Keep(hostname, filterTags) {
+		return
+	}
+
 	tags := []string{
 		fmt.Sprintf("peer.hostname:%s", hostname),
 		fmt.Sprintf("http.status_code:%s", statusCodeStr),
@@ -143,6 +412,7 @@ func (p *DatadogPlugin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		fmt.Sprintf("env:%s", p.config.Environment),
 		fmt.Sprintf("version:%s", p.config.Version),
 	}
+	tags = append(tags, p.staticTags...)
 
 	go func() {
 		p.sendMetrics(hostname, method, statusCodeStr, durationMs, isError, apdex, tags)
@@ -160,6 +430,12 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// logf prefixes log output with the plugin's alias, if set, so multi-alias
+// deployments can tell concurrent instances apart in Traefik's logs.
+func (p *DatadogPlugin) logf(format string, args ...interface{}) {
+	aliasLogf(p.alias)(format, args...)
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -168,101 +444,57 @@ func getEnv(key, defaultValue string) string {
 }
 
 func (p *DatadogPlugin) sendMetrics(hostname, method, statusCode string, durationMs float64, isError bool, apdex float64, tags []string) {
+	tagString := strings.Join(tags, ",")
+
+	// request.duration is folded into a per-tagset DDSketch and shipped as
+	// a "|d" distribution (plus derived gauges) on the next aggregator
+	// flush, instead of one "|h" histogram sample per request.
+	p.aggregator.ObserveDuration(tagString, durationMs)
+
 	metrics := []string{
-		fmt.Sprintf("trace.traefik.request.hits:1|c|#%s", strings.Join(tags, ",")),
-		fmt.Sprintf("trace.traefik.request.hits.by_http_status:1|c|#%s,status:%s", strings.Join(tags, ","), statusCode),
-		fmt.Sprintf("trace.traefik.request.duration:%.2f|h|#%s", durationMs, strings.Join(tags, ",")),
-		fmt.Sprintf("trace.traefik.request.duration.by_http_status:%.2f|h|#%s,status:%s", durationMs, strings.Join(tags, ","), statusCode),
-		fmt.Sprintf("trace.traefik.request.apdex:%.2f|g|#%s", apdex, strings.Join(tags, ",")),
+		fmt.Sprintf("trace.traefik.request.hits:1|c|#%s", tagString),
+		fmt.Sprintf("trace.traefik.request.hits.by_http_status:1|c|#%s,status:%s", tagString, statusCode),
+		fmt.Sprintf("trace.traefik.request.duration.by_http_status:%.2f|h|#%s,status:%s", durationMs, tagString, statusCode),
+		fmt.Sprintf("trace.traefik.request.apdex:%.2f|g|#%s", apdex, tagString),
 	}
 
 	if isError {
 		metrics = append(metrics,
-			fmt.Sprintf("trace.traefik.request.errors:1|c|#%s", strings.Join(tags, ",")),
-			fmt.Sprintf("trace.traefik.request.errors.by_http_status:1|c|#%s,status:%s", strings.Join(tags, ","), statusCode),
+			fmt.Sprintf("trace.traefik.request.errors:1|c|#%s", tagString),
+			fmt.Sprintf("trace.traefik.request.errors.by_http_status:1|c|#%s,status:%s", tagString, statusCode),
 		)
 	}
 
 	for _, metric := range metrics {
-		_, err := p.statsdConn.Write([]byte(metric + "\n"))
-		if err != nil {
-			log.Printf("Failed to send metric: %v", err)
-		}
+		p.aggregator.WriteMetric(metric)
 	}
 }
 
 func (p *DatadogPlugin) sendTrace(hostname, method string, statusCode int, startTime time.Time, durationMs float64, url string) {
-	traceID := generateID()
-	spanID := generateID()
-
 	startNano := startTime.UnixNano()
-	endNano := startNano + int64(durationMs*1e6)
-
-	tracePayload := map[string]interface{}{
-		"resourceSpans": []map[string]interface{}{
-			{
-				"resource": map[string]interface{}{
-					"attributes": []map[string]interface{}{
-						{"key": "service.name", "value": map[string]interface{}{"stringValue": p.config.ServiceName}},
-						{"key": "service.version", "value": map[string]interface{}{"stringValue": p.config.Version}},
-						{"key": "deployment.environment", "value": map[string]interface{}{"stringValue": p.config.Environment}},
-					},
-				},
-				"scopeSpans": []map[string]interface{}{
-					{
-						"spans": []map[string]interface{}{
-							{
-								"traceId":           traceID,
-								"spanId":            spanID,
-								"name":              hostname,
-								"kind":              1,
-								"startTimeUnixNano": startNano,
-								"endTimeUnixNano":   endNano,
-								"attributes": []map[string]interface{}{
-									{"key": "http.method", "value": map[string]interface{}{"stringValue": method}},
-									{"key": "http.url", "value": map[string]interface{}{"stringValue": url}},
-									{"key": "peer.hostname", "value": map[string]interface{}{"stringValue": hostname}},
-									{"key": "resource_name", "value": map[string]interface{}{"stringValue": hostname}},
-									{"key": "http.status_code", "value": map[string]interface{}{"intValue": strconv.Itoa(statusCode)}},
-									{"key": "http.request.duration", "value": map[string]interface{}{"doubleValue": durationMs}},
-									{"key": "service", "value": map[string]interface{}{"stringValue": p.config.ServiceName}},
-									{"key": "env", "value": map[string]interface{}{"stringValue": p.config.Environment}},
-									{"key": "version", "value": map[string]interface{}{"stringValue": p.config.Version}},
-								},
-								"status": map[string]interface{}{
-									"code": 0,
-								},
-							},
-						},
-					},
-				},
-			},
+	span := transport.Span{
+		ServiceName:    p.config.ServiceName,
+		ServiceVersion: p.config.Version,
+		Environment:    p.config.Environment,
+		Name:           hostname,
+		TraceID:        generateID(),
+		SpanID:         generateID(),
+		StartUnixNano:  startNano,
+		EndUnixNano:    startNano + int64(durationMs*1e6),
+		Attributes: map[string]interface{}{
+			"http.method":           method,
+			"http.url":              url,
+			"peer.hostname":         hostname,
+			"resource_name":         hostname,
+			"http.status_code":      strconv.Itoa(statusCode),
+			"http.request.duration": durationMs,
+			"service":               p.config.ServiceName,
+			"env":                   p.config.Environment,
+			"version":               p.config.Version,
 		},
 	}
 
-	jsonData, err := json.Marshal(tracePayload)
-	if err != nil {
-		log.Printf("Failed to marshal trace: %v", err)
-		return
-	}
-
-	req, err := http.NewRequest("POST", p.config.OTLPEndpoint, bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Printf("Failed to create trace request: %v", err)
-		return
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := p.otlpClient.Do(req)
-	if err != nil {
-		log.Printf("Failed to send trace: %v", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("OTLP endpoint returned non-OK status: %d", resp.StatusCode)
-	}
+	p.aggregator.QueueSpan(span)
 }
 
 func generateID() string {
@@ -2,79 +2,195 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
-	"net"
-	"net/http"
+	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/vhicoputra/traefik-datadog-plugin/aggregator"
+	"github.com/vhicoputra/traefik-datadog-plugin/filter"
+	"github.com/vhicoputra/traefik-datadog-plugin/healthcheck"
+	"github.com/vhicoputra/traefik-datadog-plugin/transport"
 )
 
 type AccessLog struct {
-	StartUTC            string `json:"StartUTC"`
-	StartLocal          string `json:"StartLocal"`
-	Duration            int64  `json:"Duration"`
-	ClientHost          string `json:"ClientHost"`
-	RequestHost         string `json:"RequestHost"`
-	RequestAddr         string `json:"RequestAddr"` // Traefik sometimes uses this for host
-	RequestMethod       string `json:"RequestMethod"`
-	RequestPath         string `json:"RequestPath"`
-	RequestProtocol     string `json:"RequestProtocol"`
-	RequestScheme       string `json:"RequestScheme"`
-	DownstreamStatus    int    `json:"DownstreamStatus"`
-	OriginStatus        int    `json:"OriginStatus"`
-	RouterName          string `json:"RouterName"`
-	ServiceName         string `json:"ServiceName"`
+	StartUTC         string `json:"StartUTC"`
+	StartLocal       string `json:"StartLocal"`
+	Duration         int64  `json:"Duration"`
+	ClientHost       string `json:"ClientHost"`
+	RequestHost      string `json:"RequestHost"`
+	RequestAddr      string `json:"RequestAddr"` // Traefik sometimes uses this for host
+	RequestMethod    string `json:"RequestMethod"`
+	RequestPath      string `json:"RequestPath"`
+	RequestProtocol  string `json:"RequestProtocol"`
+	RequestScheme    string `json:"RequestScheme"`
+	DownstreamStatus int    `json:"DownstreamStatus"`
+	OriginStatus     int    `json:"OriginStatus"`
+	RouterName       string `json:"RouterName"`
+	ServiceName      string `json:"ServiceName"`
 }
 
 type Config struct {
-	DogStatsDAddress string
-	OTLPEndpoint     string
-	ServiceName      string
-	Environment      string
-	Version          string
-	LogFile          string
-	ApdexThreshold   float64
+	Alias          string
+	Interfaces     InterfacesConfig
+	ServiceName    string
+	Environment    string
+	Version        string
+	LogFile        string
+	ApdexThreshold float64
+	StaticTags     map[string]string
+	Filter         *filter.Rules
+
+	// Healthcheck* configure the active backend probe subsystem (see
+	// package healthcheck). HealthcheckTargets is a static service->URL
+	// map; beyond that, every ServiceName seen in the access log is
+	// registered as a target automatically using its request hostname.
+	HealthcheckEnabled  bool
+	HealthcheckInterval time.Duration
+	HealthcheckTimeout  time.Duration
+	HealthcheckRetries  int
+	HealthcheckTargets  map[string]string
+}
+
+// InterfacesConfig groups the transports metrics and traces are shipped
+// over, mirroring the plugin's Interfaces config.
+type InterfacesConfig struct {
+	DogStatsD transport.DogStatsDConfig
+	OTLP      transport.OTLPConfig
 }
 
 var onceLogProcessed sync.Once
 
 func main() {
+	dogstatsdAddress := getEnv("DOGSTATSD_ADDRESS", "datadog-apm.datadog.svc:8127")
 	cfg := &Config{
-		DogStatsDAddress: getEnv("DOGSTATSD_ADDRESS", "datadog-apm.datadog.svc:8127"),
-		OTLPEndpoint:     fmt.Sprintf("http://%s/v1/traces", strings.Replace(getEnv("DOGSTATSD_ADDRESS", "datadog-apm.datadog.svc:8127"), ":8127", ":4318", 1)),
-		ServiceName:      getEnv("SERVICE_NAME", "traefik-cfs-staging-echo"),
-		Environment:      getEnv("ENVIRONMENT", "staging"),
-		Version:          getEnv("VERSION", "3.6.7"),
-		LogFile:          getEnv("LOG_FILE", "/var/log/traefik/access.log"),
-		ApdexThreshold:   0.5,
+		Alias: getEnv("ALIAS", ""),
+		Interfaces: InterfacesConfig{
+			DogStatsD: transport.DogStatsDConfig{
+				Address: dogstatsdAddress,
+				Network: getEnv("DOGSTATSD_NETWORK", "udp"),
+			},
+			OTLP: transport.OTLPConfig{
+				Protocol: getEnv("OTLP_PROTOCOL", "http/json"),
+				Endpoint: getEnv("OTLP_ENDPOINT", fmt.Sprintf("http://%s/v1/traces", strings.Replace(dogstatsdAddress, ":8127", ":4318", 1))),
+			},
+		},
+		ServiceName:         getEnv("SERVICE_NAME", "traefik-cfs-staging-echo"),
+		Environment:         getEnv("ENVIRONMENT", "staging"),
+		Version:             getEnv("VERSION", "3.6.7"),
+		LogFile:             getEnv("LOG_FILE", "/var/log/traefik/access.log"),
+		ApdexThreshold:      0.5,
+		StaticTags:          parseTagPairs(getEnv("STATIC_TAGS", "")),
+		HealthcheckEnabled:  getEnv("HEALTHCHECK_ENABLED", "") == "1",
+		HealthcheckInterval: envDuration("HEALTHCHECK_INTERVAL_MS", healthcheck.DefaultInterval),
+		HealthcheckTimeout:  envDuration("HEALTHCHECK_TIMEOUT_MS", healthcheck.DefaultTimeout),
+		HealthcheckRetries:  envInt("HEALTHCHECK_RETRIES", healthcheck.DefaultRetries),
+		HealthcheckTargets:  parseTagPairs(getEnv("HEALTHCHECK_TARGETS", "")),
+	}
+	cfg.Filter = filter.NewRules(
+		parsePatternList(getEnv("NAMEPASS", "")),
+		parsePatternList(getEnv("NAMEDROP", "")),
+		parseTagPatternMap(getEnv("TAGPASS", "")),
+		parseTagPatternMap(getEnv("TAGDROP", "")),
+	)
+
+	// -test (or TEST_MODE=1) borrows the telegraf "-test" idea: run the full
+	// parsing and metric/trace-construction path but print what would have
+	// been shipped to stdout instead, then exit after -test-lines access
+	// log lines. Useful for validating sanitizeTagValue, hostname fallback,
+	// Apdex bucketing, and status-code derivation against real logs before
+	// pointing at a live Agent.
+	defaultTestLines := 2
+	if v := getEnv("TEST_LINES", ""); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			defaultTestLines = n
+		}
 	}
+	testMode := flag.Bool("test", getEnv("TEST_MODE", "") == "1", "print computed DogStatsD lines and OTLP payloads to stdout instead of shipping them, then exit")
+	testLines := flag.Int("test-lines", defaultTestLines, "number of access log lines to process in -test mode before exiting")
+	flag.Parse()
+
+	var metricsSink transport.MetricsSink
+	var traceExporter transport.TraceExporter
+	if *testMode {
+		metricsSink = transport.NewTestMetricsSink(os.Stdout)
+		traceExporter = transport.NewTestTraceExporter(os.Stdout)
+	} else {
+		var err error
+		metricsSink, err = transport.NewMetricsSink(cfg.Interfaces.DogStatsD)
+		if err != nil {
+			log.Fatalf("Failed to create DogStatsD sink: %v", err)
+		}
 
-	// Connect to DogStatsD
-	addr, err := net.ResolveUDPAddr("udp", cfg.DogStatsDAddress)
-	if err != nil {
-		log.Fatalf("Failed to resolve DogStatsD address: %v", err)
+		traceExporter, err = transport.NewTraceExporter(cfg.Interfaces.OTLP)
+		if err != nil {
+			log.Fatalf("Failed to create OTLP trace exporter: %v", err)
+		}
 	}
 
-	conn, err := net.DialUDP("udp", nil, addr)
-	if err != nil {
-		log.Fatalf("Failed to connect to DogStatsD: %v", err)
+	maxPayloadBytes := cfg.Interfaces.DogStatsD.MaxPayloadBytes
+	if maxPayloadBytes <= 0 && cfg.Interfaces.DogStatsD.Network == "unixgram" {
+		maxPayloadBytes = aggregator.DefaultMaxPayloadBytesUDS
+	}
+	agg := aggregator.New(metricsSink, traceExporter, aggregator.Config{
+		MaxPayloadBytes:     maxPayloadBytes,
+		BufferFlushInterval: time.Duration(cfg.Interfaces.DogStatsD.BufferFlushIntervalMS) * time.Millisecond,
+	}, cfg.logf)
+	if !*testMode {
+		agg.Start()
+	}
+	defer agg.Close()
+
+	// The healthcheck scheduler shares agg, so probe results are batched
+	// and rate-limited exactly like request metrics instead of bypassing
+	// the aggregator with their own writes. Every ServiceName seen in the
+	// access log is registered as a target automatically (see
+	// processLogLine); HealthcheckTargets adds any that should be probed
+	// even before they've shown up in traffic.
+	var healthchecker *healthcheck.Scheduler
+	if cfg.HealthcheckEnabled {
+		healthchecker = healthcheck.New(agg, healthcheck.Config{
+			Interval:    cfg.HealthcheckInterval,
+			Timeout:     cfg.HealthcheckTimeout,
+			Retries:     cfg.HealthcheckRetries,
+			Environment: cfg.Environment,
+		}, cfg.logf)
+		for service, target := range cfg.HealthcheckTargets {
+			if u, err := url.Parse(target); err == nil {
+				healthchecker.AddTarget(service, u)
+			} else {
+				cfg.logf("Skipping invalid healthcheck target %q for service %q: %v", target, service, err)
+			}
+		}
+		if !*testMode {
+			healthchecker.Start()
+		}
 	}
-	defer conn.Close()
 
-	// HTTP client for OTLP
-	otlpClient := &http.Client{
-		Timeout: 10 * time.Second,
+	// linesProcessed counts access log lines handled so far; in -test mode
+	// each line is flushed synchronously and processing stops once
+	// testLines is reached instead of running on the flush ticker forever.
+	linesProcessed := 0
+	handleLine := func(accessLog *AccessLog) (done bool) {
+		processLogLine(agg, healthchecker, cfg, accessLog, *testMode)
+		if !*testMode {
+			return false
+		}
+		agg.Flush()
+		linesProcessed++
+		return linesProcessed >= *testLines
 	}
 
 	// Support reading from stdin (when LOG_FILE is "-") or from file
 	if cfg.LogFile == "-" {
-		log.Printf("Starting Datadog sidecar - reading from stdin")
+		cfg.logf("Starting Datadog sidecar - reading from stdin")
 		scanner := bufio.NewScanner(os.Stdin)
 		// Increase buffer size for long log lines (default 64KB may be too small under load)
 		buf := make([]byte, 0, 256*1024)
@@ -89,23 +205,25 @@ func main() {
 
 			var accessLog AccessLog
 			if err := json.Unmarshal([]byte(line), &accessLog); err != nil {
-				log.Printf("Failed to parse access log line: %v (first 80 chars: %q)", err, truncate(line, 80))
+				cfg.logf("Failed to parse access log line: %v (first 80 chars: %q)", err, truncate(line, 80))
 				continue
 			}
 
-			processLogLine(conn, otlpClient, cfg, &accessLog)
+			if handleLine(&accessLog) {
+				return
+			}
 		}
 
 		if err := scanner.Err(); err != nil {
 			log.Fatalf("Error reading stdin: %v", err)
 		}
 	} else {
-		log.Printf("Starting Datadog sidecar - reading from %s", cfg.LogFile)
+		cfg.logf("Starting Datadog sidecar - reading from %s", cfg.LogFile)
 		// Tail the log file continuously: keep file open and read new lines as they're appended.
 		for {
 			file, err := os.Open(cfg.LogFile)
 			if err != nil {
-				log.Printf("Waiting for log file (will retry): %v", err)
+				cfg.logf("Waiting for log file (will retry): %v", err)
 				time.Sleep(5 * time.Second)
 				continue
 			}
@@ -113,7 +231,7 @@ func main() {
 			// Seek to end to skip existing content and only process new lines
 			_, err = file.Seek(0, 2)
 			if err != nil {
-				log.Printf("Seek failed: %v", err)
+				cfg.logf("Seek failed: %v", err)
 				file.Close()
 				time.Sleep(5 * time.Second)
 				continue
@@ -133,17 +251,19 @@ func main() {
 
 					var accessLog AccessLog
 					if err := json.Unmarshal([]byte(line), &accessLog); err != nil {
-						log.Printf("Failed to parse access log line: %v (first 80 chars: %q)", err, truncate(line, 80))
+						cfg.logf("Failed to parse access log line: %v (first 80 chars: %q)", err, truncate(line, 80))
 						continue
 					}
 
-					processLogLine(conn, otlpClient, cfg, &accessLog)
+					if handleLine(&accessLog) {
+						return
+					}
 					continue
 				}
 
 				// EOF or error: don't close file so we can read newly appended data
 				if err := scanner.Err(); err != nil {
-					log.Printf("Error reading log: %v", err)
+					cfg.logf("Error reading log: %v", err)
 					file.Close()
 					break
 				}
@@ -155,7 +275,7 @@ func main() {
 	}
 }
 
-func processLogLine(conn *net.UDPConn, otlpClient *http.Client, cfg *Config, accessLog *AccessLog) {
+func processLogLine(agg *aggregator.Aggregator, healthchecker *healthcheck.Scheduler, cfg *Config, accessLog *AccessLog, testMode bool) {
 	// Extract hostname (matches Nginx behavior); Traefik may use RequestHost or RequestAddr
 	hostname := accessLog.RequestHost
 	if hostname == "" {
@@ -165,6 +285,13 @@ func processLogLine(conn *net.UDPConn, otlpClient *http.Client, cfg *Config, acc
 		hostname = "unknown"
 	}
 
+	// Register this line's service as a healthcheck target the first time
+	// its service+hostname pair is seen, using the request hostname as the
+	// probed endpoint.
+	if healthchecker != nil && accessLog.ServiceName != "" {
+		healthchecker.AddTarget(accessLog.ServiceName, &url.URL{Scheme: "http", Host: hostname})
+	}
+
 	// Get status code
 	statusCode := accessLog.DownstreamStatus
 	if statusCode == 0 {
@@ -186,6 +313,28 @@ func processLogLine(conn *net.UDPConn, otlpClient *http.Client, cfg *Config, acc
 		apdex = 0.5
 	}
 
+	// Route/drop this line per the configured tagpass/tagdrop/namepass/namedrop
+	// before it reaches the transport, so noisy routers or statuses never hit
+	// the Agent. RouterName is the natural "name" here since, unlike the
+	// middleware, the sidecar reads it straight off the access log.
+	routerName := accessLog.RouterName
+	if routerName == "" {
+		routerName = hostname
+	}
+	filterTags := map[string]string{
+		"peer.hostname":    hostname,
+		"http.status_code": statusCodeStr,
+		"http.method":      accessLog.RequestMethod,
+		"service":          cfg.ServiceName,
+		"env":              cfg.Environment,
+		"version":          cfg.Version,
+		"RouterName":       accessLog.RouterName,
+		"ServiceName":      accessLog.ServiceName,
+	}
+	if !cfg.Filter.Keep(routerName, filterTags) {
+		return
+	}
+
 	// Prepare tags (matching Nginx format exactly). Sanitize values so commas/pipes don't break DogStatsD.
 	tags := []string{
 		fmt.Sprintf("peer.hostname:%s", sanitizeTagValue(hostname)),
@@ -196,24 +345,32 @@ func processLogLine(conn *net.UDPConn, otlpClient *http.Client, cfg *Config, acc
 		fmt.Sprintf("env:%s", sanitizeTagValue(cfg.Environment)),
 		fmt.Sprintf("version:%s", sanitizeTagValue(cfg.Version)),
 	}
+	tags = append(tags, staticTagStrings(cfg.StaticTags)...)
 
 	// Log once when first line is processed (confirms sidecar is reading and parsing)
 	onceLogProcessed.Do(func() {
-		log.Printf("First access log line processed, sending metrics/traces to Datadog (hostname=%s)", hostname)
+		cfg.logf("First access log line processed, sending metrics/traces to Datadog (hostname=%s)", hostname)
 	})
 
 	// Send metrics (matching Nginx metric names exactly)
-	sendMetrics(conn, statusCodeStr, durationMs, isError, apdex, tags)
+	sendMetrics(agg, cfg, statusCodeStr, durationMs, isError, apdex, tags)
 
 	// Send trace with correct resource_name (recover panic so sidecar keeps running)
-	go func() {
+	sendTraceFn := func() {
 		defer func() {
 			if r := recover(); r != nil {
-				log.Printf("sendTrace panic recovered: %v", r)
+				cfg.logf("sendTrace panic recovered: %v", r)
 			}
 		}()
-		sendTrace(otlpClient, cfg.OTLPEndpoint, hostname, accessLog.RequestMethod, statusCode, durationMs, accessLog.RequestPath, cfg)
-	}()
+		sendTrace(agg, hostname, accessLog.RequestMethod, statusCode, durationMs, accessLog.RequestPath, cfg)
+	}
+	// In -test mode, run synchronously so the printed output for a line is
+	// flushed before the next line (or exit) rather than racing it.
+	if testMode {
+		sendTraceFn()
+	} else {
+		go sendTraceFn()
+	}
 }
 
 func truncate(s string, max int) string {
@@ -231,108 +388,172 @@ func sanitizeTagValue(s string) string {
 	return s
 }
 
-func sendMetrics(conn *net.UDPConn, statusCode string, durationMs float64, isError bool, apdex float64, tags []string) {
+func sendMetrics(agg *aggregator.Aggregator, cfg *Config, statusCode string, durationMs float64, isError bool, apdex float64, tags []string) {
+	tagString := strings.Join(tags, ",")
+
+	// request.duration is folded into a per-tagset DDSketch and shipped as
+	// a "|d" distribution (plus derived gauges) on the next aggregator
+	// flush, instead of one "|h" histogram sample per request.
+	agg.ObserveDuration(tagString, durationMs)
+
 	// Match Nginx metric names exactly (percentile distribution metric reverted)
 	metrics := []string{
-		fmt.Sprintf("trace.traefik.request.hits:1|c|#%s", strings.Join(tags, ",")),
-		fmt.Sprintf("trace.traefik.request.hits.by_http_status:1|c|#%s,status:%s", strings.Join(tags, ","), statusCode),
-		fmt.Sprintf("trace.traefik.request.duration:%.2f|h|#%s", durationMs, strings.Join(tags, ",")),
-		fmt.Sprintf("trace.traefik.request.duration.by_http_status:%.2f|h|#%s,status:%s", durationMs, strings.Join(tags, ","), statusCode),
-		fmt.Sprintf("trace.traefik.request.apdex:%.2f|g|#%s", apdex, strings.Join(tags, ",")),
+		fmt.Sprintf("trace.traefik.request.hits:1|c|#%s", tagString),
+		fmt.Sprintf("trace.traefik.request.hits.by_http_status:1|c|#%s,status:%s", tagString, statusCode),
+		fmt.Sprintf("trace.traefik.request.duration.by_http_status:%.2f|h|#%s,status:%s", durationMs, tagString, statusCode),
+		fmt.Sprintf("trace.traefik.request.apdex:%.2f|g|#%s", apdex, tagString),
 	}
 
 	if isError {
 		metrics = append(metrics,
-			fmt.Sprintf("trace.traefik.request.errors:1|c|#%s", strings.Join(tags, ",")),
-			fmt.Sprintf("trace.traefik.request.errors.by_http_status:1|c|#%s,status:%s", strings.Join(tags, ","), statusCode),
+			fmt.Sprintf("trace.traefik.request.errors:1|c|#%s", tagString),
+			fmt.Sprintf("trace.traefik.request.errors.by_http_status:1|c|#%s,status:%s", tagString, statusCode),
 		)
 	}
 
 	for _, metric := range metrics {
-		payload := []byte(metric + "\n")
-		if _, err := conn.Write(payload); err != nil {
-			log.Printf("Failed to send metric to DogStatsD: %v (metric=%s)", err, truncate(metric, 80))
-		}
+		agg.WriteMetric(metric)
 	}
 }
 
-func sendTrace(client *http.Client, endpoint, hostname, method string, statusCode int, durationMs float64, url string, cfg *Config) {
-	traceID := fmt.Sprintf("%032x", time.Now().UnixNano())
-	spanID := fmt.Sprintf("%016x", time.Now().UnixNano())
-
-	startTime := time.Now()
-	startNano := startTime.UnixNano()
-	endNano := startNano + int64(durationMs*1e6)
+func sendTrace(agg *aggregator.Aggregator, hostname, method string, statusCode int, durationMs float64, url string, cfg *Config) {
+	startNano := time.Now().UnixNano()
 
 	// Use http.route with hostname to help Datadog APM show hostname instead of just "GET"
 	// Datadog derives resource name from http.method + http.route, so setting http.route to hostname
 	// should make the resource appear as "GET api-dummy-cfs-traefik.mekari.io" or similar
 	httpRoute := hostname
 
-	tracePayload := map[string]interface{}{
-		"resourceSpans": []map[string]interface{}{
-			{
-				"resource": map[string]interface{}{
-					"attributes": []map[string]interface{}{
-						{"key": "service.name", "value": map[string]interface{}{"stringValue": cfg.ServiceName}},
-						{"key": "service.version", "value": map[string]interface{}{"stringValue": cfg.Version}},
-						{"key": "deployment.environment", "value": map[string]interface{}{"stringValue": cfg.Environment}},
-					},
-				},
-				"scopeSpans": []map[string]interface{}{
-					{
-						"spans": []map[string]interface{}{
-							{
-								"traceId":           traceID,
-								"spanId":            spanID,
-								"name":              fmt.Sprintf("%s %s", method, httpRoute), // Use "METHOD hostname" format
-								"kind":              1,
-								"startTimeUnixNano": startNano,
-								"endTimeUnixNano":   endNano,
-								"attributes": []map[string]interface{}{
-									{"key": "http.method", "value": map[string]interface{}{"stringValue": method}},
-									{"key": "http.route", "value": map[string]interface{}{"stringValue": httpRoute}}, // Set http.route to hostname
-									{"key": "http.url", "value": map[string]interface{}{"stringValue": url}},
-									{"key": "peer.hostname", "value": map[string]interface{}{"stringValue": hostname}},
-									{"key": "resource_name", "value": map[string]interface{}{"stringValue": hostname}},
-									{"key": "http.status_code", "value": map[string]interface{}{"intValue": strconv.Itoa(statusCode)}},
-									{"key": "http.request.duration", "value": map[string]interface{}{"doubleValue": durationMs}},
-									{"key": "service", "value": map[string]interface{}{"stringValue": cfg.ServiceName}},
-									{"key": "env", "value": map[string]interface{}{"stringValue": cfg.Environment}},
-									{"key": "version", "value": map[string]interface{}{"stringValue": cfg.Version}},
-								},
-								"status": map[string]interface{}{
-									"code": 0,
-								},
-							},
-						},
-					},
-				},
-			},
+	span := transport.Span{
+		ServiceName:    cfg.ServiceName,
+		ServiceVersion: cfg.Version,
+		Environment:    cfg.Environment,
+		Name:           fmt.Sprintf("%s %s", method, httpRoute), // "METHOD hostname" format
+		TraceID:        fmt.Sprintf("%032x", time.Now().UnixNano()),
+		SpanID:         fmt.Sprintf("%016x", time.Now().UnixNano()),
+		StartUnixNano:  startNano,
+		EndUnixNano:    startNano + int64(durationMs*1e6),
+		Attributes: map[string]interface{}{
+			"http.method":           method,
+			"http.route":            httpRoute, // Set http.route to hostname
+			"http.url":              url,
+			"peer.hostname":         hostname,
+			"resource_name":         hostname,
+			"http.status_code":      strconv.Itoa(statusCode),
+			"http.request.duration": durationMs,
+			"service":               cfg.ServiceName,
+			"env":                   cfg.Environment,
+			"version":               cfg.Version,
 		},
 	}
 
-	jsonData, err := json.Marshal(tracePayload)
-	if err != nil {
-		return
+	agg.QueueSpan(span)
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
 	}
+	return defaultValue
+}
 
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return
+// envDuration parses key as a millisecond count, falling back to
+// defaultValue if it's unset or invalid.
+func envDuration(key string, defaultValue time.Duration) time.Duration {
+	v := getEnv(key, "")
+	if v == "" {
+		return defaultValue
+	}
+	ms, err := strconv.Atoi(v)
+	if err != nil || ms <= 0 {
+		return defaultValue
 	}
+	return time.Duration(ms) * time.Millisecond
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := client.Do(req)
+// envInt parses key as an int, falling back to defaultValue if it's unset
+// or invalid.
+func envInt(key string, defaultValue int) int {
+	v := getEnv(key, "")
+	if v == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(v)
 	if err != nil {
-		return
+		return defaultValue
 	}
-	defer resp.Body.Close()
+	return n
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// logf prefixes log output with the sidecar's alias, if set, so multiple
+// aliased sidecars running against the same Traefik instance can be told
+// apart in the logs.
+func (c *Config) logf(format string, args ...interface{}) {
+	if c.Alias != "" {
+		format = "[" + c.Alias + "] " + format
 	}
-	return defaultValue
+	log.Printf(format, args...)
+}
+
+// staticTagStrings renders a static tag map into sorted "key:value" pairs
+// so each alias's extra tags are appended in a stable order.
+func staticTagStrings(tags map[string]string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, fmt.Sprintf("%s:%s", k, sanitizeTagValue(tags[k])))
+	}
+	return out
+}
+
+// parseTagPairs parses a "key=value,key2=value2" env var into a map, the
+// same shape as STATIC_TAGS, so operators can attach alias-specific static
+// tags without a config file.
+func parseTagPairs(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+	out := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k == "" {
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// parsePatternList parses a comma-separated NAMEPASS/NAMEDROP env var into
+// a pattern slice.
+func parsePatternList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// parseTagPatternMap parses a TAGPASS/TAGDROP env var of the form
+// "tag=pattern1|pattern2,tag2=pattern3" into the map[string][]string shape
+// filter.NewRules expects.
+func parseTagPatternMap(s string) map[string][]string {
+	if s == "" {
+		return nil
+	}
+	out := make(map[string][]string)
+	for _, pair := range strings.Split(s, ",") {
+		tag, patterns, ok := strings.Cut(pair, "=")
+		if !ok || tag == "" {
+			continue
+		}
+		out[tag] = strings.Split(patterns, "|")
+	}
+	return out
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestSidecarTestMode runs the sidecar binary against testdata/access_logs/
+// basic.log in -test mode and checks what it would have shipped: the
+// DogStatsD lines (matched against a golden file byte-for-byte, since
+// they're built from an ordered tag slice) and the OTLP trace batches
+// (matched field-by-field, since span.Attributes is a map and the
+// trace/span IDs and timestamps are derived from time.Now() on every run).
+func TestSidecarTestMode(t *testing.T) {
+	fixture, err := os.Open("testdata/access_logs/basic.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fixture.Close()
+
+	cmd := exec.Command("go", "run", ".", "-test", "-test-lines=2")
+	cmd.Env = append(os.Environ(),
+		"LOG_FILE=-",
+		"SERVICE_NAME=test-service",
+		"ENVIRONMENT=test",
+		"VERSION=1.0.0",
+		"STATIC_TAGS=",
+	)
+	cmd.Stdin = fixture
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("sidecar -test run failed: %v\nstderr:\n%s", err, stderr.String())
+	}
+
+	metricLines, traceBatches := splitTestOutput(out.String())
+
+	want, err := os.ReadFile("testdata/access_logs/basic.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(metricLines, "\n") + "\n"; got != string(want) {
+		t.Errorf("DogStatsD lines mismatch:\n--- got ---\n%s\n--- want ---\n%s", got, want)
+	}
+
+	wantSpans := []struct {
+		method, route, status string
+	}{
+		{"GET", "api.example.com", "200"},
+		{"POST", "api.example.com", "404"},
+	}
+	if len(traceBatches) != len(wantSpans) {
+		t.Fatalf("got %d trace batches, want %d", len(traceBatches), len(wantSpans))
+	}
+	for i, batch := range traceBatches {
+		checkTraceBatch(t, batch, wantSpans[i].method, wantSpans[i].route, wantSpans[i].status)
+	}
+}
+
+// splitTestOutput separates a -test run's stdout into the DogStatsD lines
+// it printed and the OTLP trace batches. Each batch starts with the
+// "# otlp trace batch" marker followed by json.MarshalIndent output (as
+// printed by transport.TestTraceExporter); the batch's unindented closing
+// "}" marks where the next line resumes as a DogStatsD line.
+func splitTestOutput(output string) (metricLines []string, traceBatches []string) {
+	const marker = "# otlp trace batch"
+	var current strings.Builder
+	inBatch := false
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		if inBatch {
+			current.WriteString(line)
+			current.WriteByte('\n')
+			if line == "}" {
+				traceBatches = append(traceBatches, current.String())
+				current.Reset()
+				inBatch = false
+			}
+			continue
+		}
+		if line == marker {
+			inBatch = true
+			continue
+		}
+		metricLines = append(metricLines, line)
+	}
+	return metricLines, traceBatches
+}
+
+// checkTraceBatch parses an OTLP/JSON resourceSpans batch and checks the
+// fields that are deterministic given the fixture, ignoring traceId/spanId/
+// timestamps and the attributes array's order.
+func checkTraceBatch(t *testing.T, batch, wantMethod, wantRoute, wantStatus string) {
+	t.Helper()
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(batch), &payload); err != nil {
+		t.Fatalf("failed to parse trace batch: %v\n%s", err, batch)
+	}
+
+	resourceSpans := payload["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	resourceAttrs := attrMap(resourceSpans["resource"].(map[string]interface{})["attributes"].([]interface{}))
+	if got := resourceAttrs["service.name"]; got != "test-service" {
+		t.Errorf("resource service.name = %v, want test-service", got)
+	}
+	if got := resourceAttrs["deployment.environment"]; got != "test" {
+		t.Errorf("resource deployment.environment = %v, want test", got)
+	}
+
+	spans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans in batch, want 1", len(spans))
+	}
+	span := spans[0].(map[string]interface{})
+	attrs := attrMap(span["attributes"].([]interface{}))
+
+	if got := attrs["http.method"]; got != wantMethod {
+		t.Errorf("span http.method = %v, want %s", got, wantMethod)
+	}
+	if got := attrs["http.route"]; got != wantRoute {
+		t.Errorf("span http.route = %v, want %s", got, wantRoute)
+	}
+	if got := attrs["http.status_code"]; got != wantStatus {
+		t.Errorf("span http.status_code = %v, want %s", got, wantStatus)
+	}
+}
+
+// attrMap flattens an OTLP attributes array ([{"key": k, "value": {"stringValue": v}}, ...])
+// into a plain map, keyed by attribute name, so callers don't depend on its order.
+func attrMap(attrs []interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(attrs))
+	for _, a := range attrs {
+		attr := a.(map[string]interface{})
+		value := attr["value"].(map[string]interface{})
+		for _, v := range value {
+			out[attr["key"].(string)] = v
+			break
+		}
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+)
+
+// DogStatsDConfig configures the DogStatsD metrics transport.
+type DogStatsDConfig struct {
+	// Address is a "host:port" for Network "udp", or a filesystem path for
+	// Network "unixgram" (e.g. the Agent's default /var/run/datadog/dsd.socket).
+	Address string `json:"address,omitempty"`
+	// Network selects the socket type: "udp" (default) or "unixgram".
+	Network string `json:"network,omitempty"`
+	// MaxPayloadBytes bounds how much a batched datagram is allowed to grow
+	// before it's flushed; the aggregator, not this package, enforces it.
+	MaxPayloadBytes int `json:"maxPayloadBytes,omitempty"`
+	// BufferFlushIntervalMS bounds how long a batched datagram is allowed to
+	// sit before it's flushed; the aggregator, not this package, enforces it.
+	BufferFlushIntervalMS int `json:"bufferFlushIntervalMs,omitempty"`
+}
+
+// NewMetricsSink builds the MetricsSink selected by cfg.Network.
+func NewMetricsSink(cfg DogStatsDConfig) (MetricsSink, error) {
+	switch cfg.Network {
+	case "unixgram":
+		return newUDSSink(cfg.Address)
+	case "", "udp":
+		return newUDPSink(cfg.Address)
+	default:
+		return nil, fmt.Errorf("unsupported dogstatsd network %q (want \"udp\" or \"unixgram\")", cfg.Network)
+	}
+}
+
+// udpSink ships DogStatsD lines over a connected UDP socket - the default,
+// lowest-friction transport, but subject to silent packet loss under load.
+type udpSink struct {
+	conn *net.UDPConn
+}
+
+func newUDPSink(address string) (MetricsSink, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve DogStatsD UDP address: %w", err)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial DogStatsD UDP address: %w", err)
+	}
+	return &udpSink{conn: conn}, nil
+}
+
+func (s *udpSink) Write(line string) error {
+	_, err := s.conn.Write([]byte(line + "\n"))
+	return err
+}
+
+func (s *udpSink) Close() error {
+	return s.conn.Close()
+}
+
+// udsSink ships DogStatsD lines over a Unix datagram socket, which is what
+// the Datadog Agent listens on by default at /var/run/datadog/dsd.socket.
+// Using it instead of UDP eliminates loopback packet loss under load.
+type udsSink struct {
+	conn *net.UnixConn
+}
+
+func newUDSSink(path string) (MetricsSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("dogstatsd address is required for network \"unixgram\"")
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial DogStatsD unix socket %q: %w", path, err)
+	}
+	return &udsSink{conn: conn}, nil
+}
+
+func (s *udsSink) Write(line string) error {
+	_, err := s.conn.Write([]byte(line + "\n"))
+	return err
+}
+
+func (s *udsSink) Close() error {
+	return s.conn.Close()
+}
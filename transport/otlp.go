@@ -0,0 +1,230 @@
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// OTLPConfig configures the OTLP trace transport.
+type OTLPConfig struct {
+	// Protocol selects the wire format: "http/json" (default), "http/protobuf",
+	// or "grpc".
+	Protocol string `json:"protocol,omitempty"`
+	// Endpoint is the OTLP receiver URL for the http/* protocols (e.g.
+	// "http://agent:4318/v1/traces") or the "host:port" target for grpc.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Headers are attached to every export request (e.g. an API key).
+	Headers map[string]string `json:"headers,omitempty"`
+	// Compression is "gzip" or "none" (default) for the http/* protocols.
+	Compression string    `json:"compression,omitempty"`
+	TLS         TLSConfig `json:"tls,omitempty"`
+}
+
+// TLSConfig configures transport security for the OTLP exporters.
+type TLSConfig struct {
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	CAFile             string `json:"caFile,omitempty"`
+	CertFile           string `json:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty"`
+}
+
+func (c TLSConfig) clientConfig() (*tls.Config, error) {
+	if c == (TLSConfig{}) {
+		return nil, nil
+	}
+	tlsCfg := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OTLP TLS CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in OTLP TLS CA file %q", c.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load OTLP TLS client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsCfg, nil
+}
+
+// NewTraceExporter builds the TraceExporter selected by cfg.Protocol.
+func NewTraceExporter(cfg OTLPConfig) (TraceExporter, error) {
+	tlsCfg, err := cfg.TLS.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Protocol {
+	case "", "http/json":
+		return newOTLPHTTPJSONExporter(cfg, tlsCfg), nil
+	case "http/protobuf":
+		return newOTLPHTTPProtoExporter(cfg, tlsCfg), nil
+	case "grpc":
+		return newOTLPGRPCExporter(cfg, tlsCfg)
+	default:
+		return nil, fmt.Errorf("unsupported otlp protocol %q (want \"http/json\", \"http/protobuf\" or \"grpc\")", cfg.Protocol)
+	}
+}
+
+func httpClient(tlsCfg *tls.Config) *http.Client {
+	client := &http.Client{Timeout: 5 * time.Second}
+	if tlsCfg != nil {
+		client.Transport = &http.Transport{TLSClientConfig: tlsCfg}
+	}
+	return client
+}
+
+// otlpHTTPJSONExporter POSTs each batch of spans as one OTLP
+// ExportTraceServiceRequest encoded as JSON, matching the payload the
+// plugin already built inline.
+type otlpHTTPJSONExporter struct {
+	endpoint    string
+	headers     map[string]string
+	compression string
+	client      *http.Client
+}
+
+func newOTLPHTTPJSONExporter(cfg OTLPConfig, tlsCfg *tls.Config) *otlpHTTPJSONExporter {
+	return &otlpHTTPJSONExporter{
+		endpoint:    cfg.Endpoint,
+		headers:     cfg.Headers,
+		compression: cfg.Compression,
+		client:      httpClient(tlsCfg),
+	}
+}
+
+func (e *otlpHTTPJSONExporter) Export(span Span) error {
+	return e.ExportBatch([]Span{span})
+}
+
+func (e *otlpHTTPJSONExporter) ExportBatch(spans []Span) error {
+	payload := spansToResourceSpans(spans)
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal trace: %w", err)
+	}
+
+	body, encoding, err := maybeGzip(jsonData, e.compression)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create trace request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send trace: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OTLP endpoint returned non-OK status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close is a no-op: the http.Client's idle connections aren't owned
+// exclusively by this exporter, so there's nothing to release.
+func (e *otlpHTTPJSONExporter) Close() error { return nil }
+
+// maybeGzip gzips body when compression is "gzip", returning the
+// Content-Encoding header value to set (empty when uncompressed).
+func maybeGzip(body []byte, compression string) ([]byte, string, error) {
+	if compression != "gzip" {
+		return body, "", nil
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(body); err != nil {
+		return nil, "", fmt.Errorf("failed to gzip trace payload: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, "", fmt.Errorf("failed to gzip trace payload: %w", err)
+	}
+	return buf.Bytes(), "gzip", nil
+}
+
+// spansToResourceSpans renders a batch of spans into the OTLP/JSON
+// resourceSpans shape. Spans in a batch all originate from the same plugin
+// instance, so the resource attributes are taken from the first span and
+// every span is packed into that single resourceSpans entry.
+func spansToResourceSpans(spans []Span) map[string]interface{} {
+	spanMaps := make([]map[string]interface{}, 0, len(spans))
+	for _, span := range spans {
+		attributes := make([]map[string]interface{}, 0, len(span.Attributes))
+		for k, v := range span.Attributes {
+			attributes = append(attributes, attributeValue(k, v))
+		}
+		spanMaps = append(spanMaps, map[string]interface{}{
+			"traceId":           span.TraceID,
+			"spanId":            span.SpanID,
+			"name":              span.Name,
+			"kind":              1,
+			"startTimeUnixNano": span.StartUnixNano,
+			"endTimeUnixNano":   span.EndUnixNano,
+			"attributes":        attributes,
+			"status":            map[string]interface{}{"code": 0},
+		})
+	}
+
+	first := spans[0]
+	return map[string]interface{}{
+		"resourceSpans": []map[string]interface{}{
+			{
+				"resource": map[string]interface{}{
+					"attributes": []map[string]interface{}{
+						attributeValue("service.name", first.ServiceName),
+						attributeValue("service.version", first.ServiceVersion),
+						attributeValue("deployment.environment", first.Environment),
+					},
+				},
+				"scopeSpans": []map[string]interface{}{
+					{
+						"spans": spanMaps,
+					},
+				},
+			},
+		},
+	}
+}
+
+func attributeValue(key string, v interface{}) map[string]interface{} {
+	switch val := v.(type) {
+	case string:
+		return map[string]interface{}{"key": key, "value": map[string]interface{}{"stringValue": val}}
+	case float64:
+		return map[string]interface{}{"key": key, "value": map[string]interface{}{"doubleValue": val}}
+	case int:
+		return map[string]interface{}{"key": key, "value": map[string]interface{}{"intValue": val}}
+	default:
+		return map[string]interface{}{"key": key, "value": map[string]interface{}{"stringValue": fmt.Sprintf("%v", val)}}
+	}
+}
@@ -0,0 +1,67 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// otlpGRPCExporter ships spans over the OTLP gRPC trace service, the
+// lowest-overhead of the three transports once a connection is established.
+type otlpGRPCExporter struct {
+	client  coltracepb.TraceServiceClient
+	conn    *grpc.ClientConn
+	headers map[string]string
+}
+
+func newOTLPGRPCExporter(cfg OTLPConfig, tlsCfg *tls.Config) (*otlpGRPCExporter, error) {
+	creds := insecure.NewCredentials()
+	if tlsCfg != nil {
+		creds = credentials.NewTLS(tlsCfg)
+	}
+
+	conn, err := grpc.NewClient(cfg.Endpoint, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial OTLP gRPC endpoint %q: %w", cfg.Endpoint, err)
+	}
+
+	return &otlpGRPCExporter{
+		client:  coltracepb.NewTraceServiceClient(conn),
+		conn:    conn,
+		headers: cfg.Headers,
+	}, nil
+}
+
+func (e *otlpGRPCExporter) Export(span Span) error {
+	return e.ExportBatch([]Span{span})
+}
+
+func (e *otlpGRPCExporter) ExportBatch(spans []Span) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if len(e.headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(e.headers))
+	}
+
+	req := &coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: []*tracepb.ResourceSpans{spansToResourceSpansPB(spans)},
+	}
+
+	if _, err := e.client.Export(ctx, req); err != nil {
+		return fmt.Errorf("failed to export trace over OTLP gRPC: %w", err)
+	}
+	return nil
+}
+
+func (e *otlpGRPCExporter) Close() error {
+	return e.conn.Close()
+}
@@ -0,0 +1,150 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/protobuf/proto"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+)
+
+// otlpHTTPProtoExporter POSTs each batch of spans as one protobuf-encoded
+// ExportTraceServiceRequest, cutting payload size versus the JSON encoding.
+type otlpHTTPProtoExporter struct {
+	endpoint    string
+	headers     map[string]string
+	compression string
+	client      *http.Client
+}
+
+func newOTLPHTTPProtoExporter(cfg OTLPConfig, tlsCfg *tls.Config) *otlpHTTPProtoExporter {
+	return &otlpHTTPProtoExporter{
+		endpoint:    cfg.Endpoint,
+		headers:     cfg.Headers,
+		compression: cfg.Compression,
+		client:      httpClient(tlsCfg),
+	}
+}
+
+func (e *otlpHTTPProtoExporter) Export(span Span) error {
+	return e.ExportBatch([]Span{span})
+}
+
+func (e *otlpHTTPProtoExporter) ExportBatch(spans []Span) error {
+	req := &coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: []*tracepb.ResourceSpans{spansToResourceSpansPB(spans)},
+	}
+
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal OTLP protobuf trace request: %w", err)
+	}
+
+	body, encoding, err := maybeGzip(data, e.compression)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create trace request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	if encoding != "" {
+		httpReq.Header.Set("Content-Encoding", encoding)
+	}
+	for k, v := range e.headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send trace: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OTLP endpoint returned non-OK status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close is a no-op: the http.Client's idle connections aren't owned
+// exclusively by this exporter, so there's nothing to release.
+func (e *otlpHTTPProtoExporter) Close() error { return nil }
+
+// spansToResourceSpansPB renders a batch of spans into the generated OTLP
+// protobuf types shared by the HTTP/protobuf and gRPC exporters. Spans in a
+// batch all originate from the same plugin instance, so the resource is
+// taken from the first span and every span is packed into that one
+// ResourceSpans entry.
+func spansToResourceSpansPB(spans []Span) *tracepb.ResourceSpans {
+	pbSpans := make([]*tracepb.Span, 0, len(spans))
+	for _, span := range spans {
+		attributes := make([]*commonpb.KeyValue, 0, len(span.Attributes))
+		for k, v := range span.Attributes {
+			attributes = append(attributes, attributeValuePB(k, v))
+		}
+		pbSpans = append(pbSpans, &tracepb.Span{
+			TraceId:           decodeHexID(span.TraceID),
+			SpanId:            decodeHexID(span.SpanID),
+			Name:              span.Name,
+			Kind:              tracepb.Span_SPAN_KIND_SERVER,
+			StartTimeUnixNano: uint64(span.StartUnixNano),
+			EndTimeUnixNano:   uint64(span.EndUnixNano),
+			Attributes:        attributes,
+			Status:            &tracepb.Status{Code: tracepb.Status_STATUS_CODE_OK},
+		})
+	}
+
+	first := spans[0]
+	return &tracepb.ResourceSpans{
+		Resource: &resourcepb.Resource{
+			Attributes: []*commonpb.KeyValue{
+				attributeValuePB("service.name", first.ServiceName),
+				attributeValuePB("service.version", first.ServiceVersion),
+				attributeValuePB("deployment.environment", first.Environment),
+			},
+		},
+		ScopeSpans: []*tracepb.ScopeSpans{
+			{
+				Spans: pbSpans,
+			},
+		},
+	}
+}
+
+// decodeHexID decodes a Span's hex-encoded TraceID/SpanID (e.g.
+// fmt.Sprintf("%032x", ...)) into the raw binary trace_id/span_id bytes
+// OTLP's protobuf wire format requires. An odd-length or non-hex id (which
+// shouldn't happen given how Span IDs are generated) is passed through as
+// raw bytes rather than dropped, so a malformed id still produces a span
+// instead of none at all.
+func decodeHexID(id string) []byte {
+	decoded, err := hex.DecodeString(id)
+	if err != nil {
+		return []byte(id)
+	}
+	return decoded
+}
+
+func attributeValuePB(key string, v interface{}) *commonpb.KeyValue {
+	switch val := v.(type) {
+	case string:
+		return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: val}}}
+	case float64:
+		return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: val}}}
+	case int:
+		return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(val)}}}
+	default:
+		return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: fmt.Sprintf("%v", val)}}}
+	}
+}
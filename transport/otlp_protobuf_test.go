@@ -0,0 +1,61 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+)
+
+// TestOTLPHTTPProtoExporterTraceAndSpanIDLength round-trips a span through
+// otlpHTTPProtoExporter/spansToResourceSpansPB and decodes the protobuf body
+// the exporter actually sent over the wire, so a regression like shipping
+// hex-encoded ASCII instead of raw trace_id/span_id bytes (which the
+// http/json path's plain string serialization doesn't expose) fails this
+// test instead of only failing against a real, spec-compliant collector.
+func TestOTLPHTTPProtoExporterTraceAndSpanIDLength(t *testing.T) {
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("failed to read request body: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	exporter := newOTLPHTTPProtoExporter(OTLPConfig{Endpoint: srv.URL}, nil)
+
+	now := time.Now().UnixNano()
+	span := Span{
+		ServiceName:   "test-service",
+		Name:          "test-span",
+		TraceID:       fmt.Sprintf("%032x", now),
+		SpanID:        fmt.Sprintf("%016x", now),
+		StartUnixNano: now,
+		EndUnixNano:   now + 1,
+	}
+	if err := exporter.Export(span); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	var req coltracepb.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		t.Fatalf("failed to unmarshal request body: %v", err)
+	}
+
+	gotSpan := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if got := len(gotSpan.TraceId); got != 16 {
+		t.Errorf("TraceId length = %d, want 16", got)
+	}
+	if got := len(gotSpan.SpanId); got != 8 {
+		t.Errorf("SpanId length = %d, want 8", got)
+	}
+}
@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// TestMetricsSink writes every DogStatsD line to w instead of a real socket,
+// so a --test/TestSink run can show operators exactly what would have been
+// shipped to the Agent.
+type TestMetricsSink struct {
+	w io.Writer
+}
+
+// NewTestMetricsSink builds a MetricsSink that prints every line to w.
+func NewTestMetricsSink(w io.Writer) *TestMetricsSink {
+	return &TestMetricsSink{w: w}
+}
+
+func (s *TestMetricsSink) Write(line string) error {
+	_, err := fmt.Fprintln(s.w, line)
+	return err
+}
+
+func (s *TestMetricsSink) Close() error { return nil }
+
+// TestTraceExporter pretty-prints each span batch as OTLP/JSON to w instead
+// of sending it to a collector.
+type TestTraceExporter struct {
+	w io.Writer
+}
+
+// NewTestTraceExporter builds a TraceExporter that prints every batch's
+// OTLP/JSON payload to w.
+func NewTestTraceExporter(w io.Writer) *TestTraceExporter {
+	return &TestTraceExporter{w: w}
+}
+
+func (e *TestTraceExporter) Export(span Span) error {
+	return e.ExportBatch([]Span{span})
+}
+
+func (e *TestTraceExporter) ExportBatch(spans []Span) error {
+	data, err := json.MarshalIndent(spansToResourceSpans(spans), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal test trace payload: %w", err)
+	}
+	if _, err := fmt.Fprintln(e.w, "# otlp trace batch"); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(e.w, string(data))
+	return err
+}
+
+func (e *TestTraceExporter) Close() error { return nil }
@@ -0,0 +1,42 @@
+// Package transport implements the wire-level sinks and exporters the
+// middleware and sidecar ship metrics and traces through. ServeHTTP and
+// processLogLine build the metric lines and the Span once and hand them to
+// whichever MetricsSink/TraceExporter the Interfaces config selected,
+// instead of writing to a *net.UDPConn or POSTing a JSON map inline.
+package transport
+
+// MetricsSink ships one DogStatsD datagram, a single line or several
+// "\n"-separated lines (without a trailing newline, Write appends it), to
+// the Datadog Agent.
+type MetricsSink interface {
+	Write(line string) error
+	Close() error
+}
+
+// Span is the exporter-agnostic representation of one HTTP request span.
+// Each TraceExporter implementation translates it into its own wire format
+// (an OTLP/JSON resourceSpans payload, an OTLP protobuf
+// ExportTraceServiceRequest sent over HTTP or gRPC, ...).
+type Span struct {
+	ServiceName    string
+	ServiceVersion string
+	Environment    string
+	Name           string
+	TraceID        string
+	SpanID         string
+	StartUnixNano  int64
+	EndUnixNano    int64
+	Attributes     map[string]interface{}
+}
+
+// TraceExporter ships spans to the Agent's trace receiver. ExportBatch packs
+// every span it's given into a single request, so the aggregator can flush
+// a whole interval's worth of spans over one HTTP POST/gRPC call instead of
+// one per request. Export is the single-span convenience case. Close
+// releases any held connection (the gRPC exporter's ClientConn; a no-op for
+// the HTTP-based exporters).
+type TraceExporter interface {
+	Export(span Span) error
+	ExportBatch(spans []Span) error
+	Close() error
+}